@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errUpstreamUnavailable marks failures caused by the upstream host itself: 5xx, 429, timeouts and broken connections.
+var errUpstreamUnavailable = errors.New("upstream unavailable")
+
+// errOutageTooLong is returned to a requeued request once it waited longer than the
+// breaker allows for an outage to end.
+var errOutageTooLong = errors.New("upstream outage lasts too long")
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breakerPollInterval is how often paused requests look at the breaker again.
+const breakerPollInterval = 200 * time.Millisecond
+
+// circuitBreaker guards the upstream host. it opens when the error rate over the last
+// window requests reaches the threshold, which pauses every request of every stage.
+// after cooldown a single half-open probe is let through: success closes the breaker,
+// failure opens it again. a request requeued by an outage waits at most maxWait in
+// total, 0 waits until the upstream recovers.
+type circuitBreaker struct {
+	threshold  float64
+	minSamples int
+	cooldown   time.Duration
+	maxWait    time.Duration
+
+	mu         sync.Mutex
+	state      int
+	outcomes   []bool // ring buffer of recent request results, true means failed
+	next       int
+	filled     int
+	failures   int
+	openedAt   time.Time
+	generation int64 // increased each time the breaker opens
+	probing    bool
+	trips      int64
+	requeued   int64
+}
+
+func newCircuitBreaker(threshold float64, window int, cooldown, maxWait time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:  threshold,
+		minSamples: window / 2,
+		cooldown:   cooldown,
+		maxWait:    maxWait,
+		outcomes:   make([]bool, window),
+	}
+}
+
+// wait blocks while the breaker is open. it returns the breaker generation the request
+// runs in, and whether the request is the half-open probe. a non zero deadline ends the
+// wait with errOutageTooLong.
+func (b *circuitBreaker) wait(deadline time.Time) (int64, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		switch b.state {
+		case breakerClosed:
+			return b.generation, false, nil
+		case breakerOpen:
+			if time.Since(b.openedAt) >= b.cooldown {
+				b.state = breakerHalfOpen
+				log.Infow("circuit breaker half-open, probing upstream")
+				continue
+			}
+		case breakerHalfOpen:
+			if !b.probing {
+				b.probing = true
+				return b.generation, true, nil
+			}
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return b.generation, false, errOutageTooLong
+		}
+		b.mu.Unlock()
+		time.Sleep(breakerPollInterval)
+		b.mu.Lock()
+	}
+}
+
+// record feeds the result of a request into the breaker.
+func (b *circuitBreaker) record(failed, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+		if failed {
+			b.open()
+			return
+		}
+		b.state = breakerClosed
+		b.outcomes = make([]bool, len(b.outcomes))
+		b.next, b.filled, b.failures = 0, 0, 0
+		log.Infow("circuit breaker closed, upstream recovered")
+		return
+	}
+	if b.state != breakerClosed || len(b.outcomes) == 0 {
+		// result of a request sent before the breaker opened
+		return
+	}
+	if b.filled == len(b.outcomes) {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+	} else {
+		b.filled++
+	}
+	b.outcomes[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.filled >= b.minSamples && float64(b.failures)/float64(b.filled) >= b.threshold {
+		b.trips++
+		b.open()
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	b.generation++
+	log.Warnw("circuit breaker opened, pausing all stages",
+		zap.Int("failures", b.failures),
+		zap.Int("samples", b.filled),
+		zap.Duration("cooldown", b.cooldown))
+}
+
+// outage tells whether the breaker opened since the given generation, i.e. a failure
+// of a request from that generation belongs to an upstream outage and should be requeued.
+func (b *circuitBreaker) outage(generation int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.generation != generation {
+		b.requeued++
+		return true
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestBreakerTripAndHalfOpen(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	b := newCircuitBreaker(0.5, 4, 10*time.Millisecond, 0)
+	b.record(false, false)
+	if b.state != breakerClosed {
+		t.Fatal("opened below min samples")
+	}
+	b.record(true, false)
+	if b.state != breakerOpen || b.trips != 1 || b.generation != 1 {
+		t.Fatalf("state %v trips %v generation %v after half the requests failed", b.state, b.trips, b.generation)
+	}
+	if !b.outage(0) || b.outage(1) {
+		t.Error("outage does not follow the generation")
+	}
+
+	// one probe after the cooldown, the others keep waiting
+	generation, probe, err := b.wait(time.Time{})
+	if err != nil || !probe || generation != 1 {
+		t.Fatalf("first wait: generation %v probe %v err %v", generation, probe, err)
+	}
+	if _, _, err := b.wait(time.Now().Add(50 * time.Millisecond)); !errors.Is(err, errOutageTooLong) {
+		t.Errorf("second wait during the probe: %v", err)
+	}
+
+	// a failed probe opens again, a successful one closes
+	b.record(true, true)
+	if b.state != breakerOpen || b.generation != 2 || b.trips != 1 {
+		t.Fatalf("failed probe: state %v generation %v trips %v", b.state, b.generation, b.trips)
+	}
+	if _, probe, _ := b.wait(time.Time{}); !probe {
+		t.Fatal("no probe after the second cooldown")
+	}
+	b.record(false, true)
+	if b.state != breakerClosed || b.filled != 0 {
+		t.Fatalf("successful probe: state %v, %v samples kept", b.state, b.filled)
+	}
+	if _, probe, err := b.wait(time.Time{}); probe || err != nil {
+		t.Errorf("closed breaker: probe %v err %v", probe, err)
+	}
+}
+
+func TestRequestRequeuedDuringOutage(t *testing.T) {
+	var hits int32
+	srv := testCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		// the first request and the first probe fail
+		if atomic.AddInt32(&hits, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+	upstream = newCircuitBreaker(0.5, 2, 10*time.Millisecond, time.Minute)
+	content, err := request(srv.URL, true)
+	if err != nil || string(content) != "ok" {
+		t.Fatalf("request: %q %v", content, err)
+	}
+	if hits != 3 || upstream.trips != 1 || upstream.requeued != 2 {
+		t.Errorf("%v hits, %v trips, %v requeued, want 3, 1, 2", hits, upstream.trips, upstream.requeued)
+	}
+	if crawlBudget.requests != 1 {
+		t.Errorf("requeued request charged %v requests, want 1", crawlBudget.requests)
+	}
+}
+
+func TestRequestOutageMaxWait(t *testing.T) {
+	var hits int32
+	srv := testCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	upstream = newCircuitBreaker(0.5, 2, time.Hour, 300*time.Millisecond)
+	start := time.Now()
+	if _, err := request(srv.URL, true); !errors.Is(err, errOutageTooLong) {
+		t.Fatalf("request during a long outage: %v", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("gave up after %v", waited)
+	}
+	if hits != 1 {
+		t.Errorf("%v hits while the breaker was open", hits)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errBudgetExhausted = errors.New("crawl budget exhausted")
+
+// budget caps what a single run may spend upstream, zero means unlimited.
+// once any cap is hit every later request fails with errBudgetExhausted,
+// so workers drain their channels quickly and the run can checkpoint.
+type budget struct {
+	maxRequests      int64
+	maxStageRequests map[string]int64
+	maxBytes         int64
+	deadline         time.Time
+
+	mu            sync.Mutex
+	stage         string
+	requests      int64
+	stageRequests map[string]int64
+	bytes         int64
+	reason        string
+}
+
+func newBudget(maxRequests int64, maxStageRequests map[string]int64, maxBytes int64, maxDuration time.Duration) *budget {
+	b := &budget{
+		maxRequests:      maxRequests,
+		maxStageRequests: maxStageRequests,
+		maxBytes:         maxBytes,
+		stageRequests:    make(map[string]int64),
+	}
+	if maxDuration > 0 {
+		b.deadline = time.Now().Add(maxDuration)
+	}
+	return b
+}
+
+// setStage switches the stage that following requests are charged to.
+func (b *budget) setStage(stage string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stage = stage
+}
+
+// acquire charges one request to the current stage, it fails if any cap is already reached.
+func (b *budget) acquire() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reason != "" {
+		return errBudgetExhausted
+	}
+	switch {
+	case b.maxRequests > 0 && b.requests >= b.maxRequests:
+		b.reason = fmt.Sprintf("max requests %v reached", b.maxRequests)
+	case b.maxStageRequests[b.stage] > 0 && b.stageRequests[b.stage] >= b.maxStageRequests[b.stage]:
+		b.reason = fmt.Sprintf("max requests %v of stage %v reached", b.maxStageRequests[b.stage], b.stage)
+	case b.maxBytes > 0 && b.bytes >= b.maxBytes:
+		b.reason = fmt.Sprintf("max bytes %v reached", b.maxBytes)
+	case !b.deadline.IsZero() && time.Now().After(b.deadline):
+		b.reason = fmt.Sprintf("deadline %v reached", b.deadline.Format(time.RFC3339))
+	}
+	if b.reason != "" {
+		log.Warnw("crawl budget exhausted, stopping", zap.String("reason", b.reason))
+		return errBudgetExhausted
+	}
+	b.requests++
+	b.stageRequests[b.stage]++
+	return nil
+}
+
+// consume records downloaded body bytes.
+func (b *budget) consume(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytes += int64(n)
+}
+
+func (b *budget) exhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reason != ""
+}
+
+// parseStageLimits parses "school_info=100,special_detail=5000" into a stage -> limit map.
+func parseStageLimits(s string) (map[string]int64, error) {
+	res := make(map[string]int64)
+	if s == "" {
+		return res, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		fields := strings.SplitN(kv, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid stage limit: %v", kv)
+		}
+		limit, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stage limit: %v", kv)
+		}
+		res[strings.TrimSpace(fields[0])] = limit
+	}
+	return res, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestBudgetMaxRequests(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	b := newBudget(2, nil, 0, 0)
+	for i := 0; i < 2; i++ {
+		if err := b.acquire(); err != nil {
+			t.Fatalf("request %v: %v", i, err)
+		}
+	}
+	if err := b.acquire(); !errors.Is(err, errBudgetExhausted) {
+		t.Fatalf("third request: %v, want errBudgetExhausted", err)
+	}
+	if !b.exhausted() || b.reason == "" {
+		t.Error("budget not marked exhausted")
+	}
+	if b.requests != 2 {
+		t.Errorf("%v requests charged, want 2", b.requests)
+	}
+}
+
+func TestBudgetStageRequests(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	b := newBudget(0, map[string]int64{stageSchoolInfo: 1}, 0, 0)
+	b.setStage(stageSchoolList)
+	for i := 0; i < 3; i++ {
+		if err := b.acquire(); err != nil {
+			t.Fatalf("unlimited stage: %v", err)
+		}
+	}
+	b.setStage(stageSchoolInfo)
+	if err := b.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.acquire(); !errors.Is(err, errBudgetExhausted) {
+		t.Fatalf("stage over its limit: %v", err)
+	}
+	// exhaustion stops every stage
+	b.setStage(stageSchoolList)
+	if err := b.acquire(); !errors.Is(err, errBudgetExhausted) {
+		t.Errorf("other stage after exhaustion: %v", err)
+	}
+	if b.stageRequests[stageSchoolList] != 3 || b.stageRequests[stageSchoolInfo] != 1 {
+		t.Errorf("stage requests %v", b.stageRequests)
+	}
+}
+
+func TestBudgetBytesAndDeadline(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	b := newBudget(0, nil, 100, 0)
+	if err := b.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	b.consume(100)
+	if err := b.acquire(); !errors.Is(err, errBudgetExhausted) {
+		t.Errorf("over max bytes: %v", err)
+	}
+
+	b = newBudget(0, nil, 0, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if err := b.acquire(); !errors.Is(err, errBudgetExhausted) {
+		t.Errorf("after deadline: %v", err)
+	}
+}
+
+func TestParseStageLimits(t *testing.T) {
+	limits, err := parseStageLimits("school_info=100, special_detail=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits[stageSchoolInfo] != 100 || limits[stageSpecialDetail] != 5000 {
+		t.Errorf("limits %v", limits)
+	}
+	for _, s := range []string{"school_info", "school_info=x"} {
+		if _, err := parseStageLimits(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
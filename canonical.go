@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const admissionScoreFile = "admission_scores.jsonl"
+
+// record sources
+const (
+	sourceSpecialDetail = "special_detail"       // current pipeline, special_detail/*.json
+	sourceLegacyResult  = "legacy_result"        // main_deprecated.go, result.json
+	sourcePythonScore   = "python_special_score" // python_impl_2024, school_special_score/*.json
+	sourceLegacyDetail  = "legacy_school_detail" // test/detail, school_detail/<y,s,p,t,b>.json
+)
+
+// AdmissionScore is the canonical admission score record: one special of a school in a
+// province, year, type and batch. every implementation, current or legacy, converts to it.
+type AdmissionScore struct {
+	SchoolID       string
+	SchoolName     string
+	ProvinceID     string
+	Year           int
+	Type           string
+	Batch          string
+	SpecialID      string
+	SpeID          string
+	SpecialName    string
+	SpecialGroup   string
+	Zslx           string
+	ZslxName       string
+	LocalBatchName string
+	Level1Name     string
+	Level2Name     string
+	Level3Name     string
+	Max            NullDecimal
+	Min            NullDecimal
+	Average        NullDecimal
+	MinSection     NullInt
+
+	Source     string
+	SourceFile string
+	Warnings   []string `json:",omitempty"`
+}
+
+// Key identifies the record regardless of its source. legacy records lack the ids, so
+// an unresolved school counts by its name and the zslx and local batch names are part of it.
+func (a AdmissionScore) Key() string {
+	return strings.Join([]string{firstNonEmpty(a.SchoolID, a.SchoolName), a.ProvinceID, strconv.Itoa(a.Year),
+		a.Type, a.Batch, a.SpecialID, a.SpeID, a.Zslx, a.ZslxName, a.LocalBatchName, a.SpecialGroup, a.SpecialName}, "|")
+}
+
+// admissionScoreFromSpecial converts a typed special, context fields are taken from the
+// arguments when the special itself lacks them.
+func admissionScoreFromSpecial(s TypedSpecial, schoolID, provinceID string, year int, typ, batch string) AdmissionScore {
+	return AdmissionScore{
+		SchoolID:       firstNonEmpty(s.SchoolID, schoolID),
+		SchoolName:     schoolIDNameMap[firstNonEmpty(s.SchoolID, schoolID)],
+		ProvinceID:     firstNonEmpty(s.Province, provinceID),
+		Year:           year,
+		Type:           firstNonEmpty(s.Special.Type, typ),
+		Batch:          firstNonEmpty(s.Special.Batch, batch),
+		SpecialID:      s.SpecialID,
+		SpeID:          s.SpeID,
+		SpecialName:    s.Spname,
+		SpecialGroup:   s.SpecialGroup,
+		Zslx:           s.Zslx,
+		ZslxName:       s.ZslxName,
+		LocalBatchName: s.LocalBatchName,
+		Level1Name:     s.Level1Name,
+		Level2Name:     s.Level2Name,
+		Level3Name:     s.Level3Name,
+		Max:            s.Max,
+		Min:            s.Min,
+		Average:        s.Average,
+		MinSection:     s.MinSection,
+		Warnings:       s.Warnings,
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// admissionScoresFromSchoolProv converts a special_detail file of the current pipeline.
+func admissionScoresFromSchoolProv(sp SchoolProv, file string) []AdmissionScore {
+	res := make([]AdmissionScore, 0)
+	for _, ytb := range sp.YTBSpecials {
+		year, _ := strconv.Atoi(ytb.Year)
+		for _, s := range ytb.Special {
+			a := admissionScoreFromSpecial(s, sp.SchoolID, sp.ProvinceID, year, ytb.Typ, ytb.Batch)
+			a.Source, a.SourceFile = sourceSpecialDetail, file
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// legacyResult is a line of result.json written by main_deprecated.go, which only
+// crawled province 45, type 1 and batch 7 and kept the school name but not its id.
+// depending on the url it ran with, a line holds schoolspecialindex scores or
+// schoolplanindex plans, plans carry no score and are no admission scores.
+type legacyResult struct {
+	School struct {
+		Name string `json:"school_name"`
+	}
+	Year     int
+	Specific struct {
+		Data struct {
+			Item []struct {
+				Name       string `json:"spname"`
+				SpeType    string `json:"zslx_name"`
+				Batch      string `json:"local_batch_name"`
+				Min        string `json:"min"`
+				MinSection string `json:"min_section"`
+				Max        string `json:"max"`
+				Avg        string `json:"average"`
+			} `json:"item"`
+		} `json:"data"`
+	}
+}
+
+const (
+	legacyProvince = "45"
+	legacyType     = "1"
+	legacyBatch    = "7"
+)
+
+// admissionScoresFromLegacyResult converts result.json, schoolNameIDMap resolves the school id.
+func admissionScoresFromLegacyResult(file string, schoolNameIDMap map[string]string) ([]AdmissionScore, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	res := make([]AdmissionScore, 0)
+	plans := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var lr legacyResult
+		if err := json.Unmarshal(scanner.Bytes(), &lr); err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", file, line, err)
+		}
+		if !lr.hasScores() {
+			plans++
+			continue
+		}
+		schoolID := schoolNameIDMap[lr.School.Name]
+		for _, item := range lr.Specific.Data.Item {
+			s := Special{
+				SchoolID:       schoolID,
+				Spname:         item.Name,
+				ZslxName:       item.SpeType,
+				LocalBatchName: item.Batch,
+				Min:            item.Min,
+				MinSection:     item.MinSection,
+				Max:            item.Max,
+				Average:        item.Avg,
+			}.Typed()
+			a := admissionScoreFromSpecial(s, schoolID, legacyProvince, lr.Year, legacyType, legacyBatch)
+			a.SchoolName = lr.School.Name
+			a.Source, a.SourceFile = sourceLegacyResult, fmt.Sprintf("%v:%v", file, line)
+			if schoolID == "" {
+				a.Warnings = append(a.Warnings, fmt.Sprintf("school %q not in school list", lr.School.Name))
+			}
+			res = append(res, a)
+		}
+	}
+	if plans != 0 {
+		log.Warnw("legacy result lines skipped, they hold schoolplanindex plans", zap.String("file", file), zap.Int("lines", plans))
+	}
+	return res, scanner.Err()
+}
+
+// hasScores tells whether any item of the line carries a score or rank.
+func (lr legacyResult) hasScores() bool {
+	for _, item := range lr.Specific.Data.Item {
+		for _, v := range []string{item.Min, item.MinSection, item.Max, item.Avg} {
+			if !missingValues[strings.TrimSpace(v)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// admissionScoresFromPythonScore converts school_special_score/<school>_<year>.json of
+// python_impl_2024, or <school>_<year>_<province>.json of the special score stage.
+// the file holds the data of schoolspecialscore, keyed by "<type>_<batch>_<x>",
+// e.g. 1_7_0 理科一本, whose values are item lists or pages.
+func admissionScoresFromPythonScore(file, province string) ([]AdmissionScore, error) {
+	name := strings.TrimSuffix(filepath.Base(file), ".json")
+	fields := strings.Split(name, "_")
+	switch len(fields) {
+	case 2:
+	case 3:
+		province = fields[2]
+	default:
+		return nil, fmt.Errorf("%v: file name is not <school>_<year>[_<province>].json", file)
+	}
+	schoolID := fields[0]
+	year, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%v: invalid year: %w", file, err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("%v: %w", file, err)
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	res := make([]AdmissionScore, 0)
+	for _, key := range keys {
+		raw := data[key]
+		tb := strings.Split(key, "_")
+		if len(tb) < 2 {
+			continue
+		}
+		items, err := specialItems(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%v: key %v: %w", file, key, err)
+		}
+		for _, s := range items {
+			a := admissionScoreFromSpecial(s, schoolID, province, year, tb[0], tb[1])
+			a.Source, a.SourceFile = sourcePythonScore, file
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
+// specialItems decodes either a list of items or an object with an item list.
+func specialItems(raw json.RawMessage) ([]TypedSpecial, error) {
+	var items []TypedSpecial
+	if err := json.Unmarshal(raw, &items); err == nil {
+		return items, nil
+	}
+	var page struct {
+		Item []TypedSpecial `json:"item"`
+	}
+	if err := json.Unmarshal(raw, &page); err != nil {
+		return nil, err
+	}
+	return page.Item, nil
+}
+
+// loadSchoolNames fills schoolIDNameMap from a school list file and returns name -> id.
+func loadSchoolNames(file string) map[string]string {
+	nameIDMap := make(map[string]string)
+	content, err := os.ReadFile(file)
+	if err != nil {
+		log.Warnw("school list not loaded, school names are not resolved", zap.Error(err))
+		return nameIDMap
+	}
+	var list school
+	if err := json.Unmarshal(content, &list); err != nil {
+		log.Warnw("school list not loaded, school names are not resolved", zap.Error(err))
+		return nameIDMap
+	}
+	for _, sc := range list.Data {
+		schoolIDNameMap[sc.SchoolID] = sc.Name
+		nameIDMap[sc.Name] = sc.SchoolID
+	}
+	return nameIDMap
+}
+
+// importAdmissionScores reads any supported layout at p: a special_detail dataset of
+// json files or jsonl parts, a school_special_score directory, a single file or part of
+// them, or a legacy result.json.
+func importAdmissionScores(p, province string, schoolNameIDMap map[string]string) ([]AdmissionScore, error) {
+	stat, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		isSpecialDetail := filepath.Base(filepath.Clean(p)) == specialDetailDir
+		for _, e := range entries {
+			isSpecialDetail = isSpecialDetail || jsonlPartName.MatchString(e.Name())
+		}
+		res := make([]AdmissionScore, 0)
+		if isSpecialDetail {
+			err := walkSpecialDetail(p, nil, func(source string, sp SchoolProv) error {
+				res = append(res, admissionScoresFromSchoolProv(sp, source)...)
+				return nil
+			})
+			return res, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+				continue
+			}
+			records, err := importAdmissionScores(path.Join(p, e.Name()), province, schoolNameIDMap)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, records...)
+		}
+		return res, nil
+	}
+	switch {
+	case jsonlPartName.MatchString(filepath.Base(p)):
+		res := make([]AdmissionScore, 0)
+		err := readPart(p, func(line int, key string, data json.RawMessage) error {
+			var sp SchoolProv
+			if err := json.Unmarshal(data, &sp); err != nil {
+				return fmt.Errorf("%v:%v: %w", p, line, err)
+			}
+			res = append(res, admissionScoresFromSchoolProv(sp, fmt.Sprintf("%v:%v", p, line))...)
+			return nil
+		})
+		return res, err
+	case strings.HasSuffix(p, ".jsonl") || filepath.Base(p) == "result.json":
+		return admissionScoresFromLegacyResult(p, schoolNameIDMap)
+	case filepath.Base(filepath.Dir(p)) == specialDetailDir:
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var sp SchoolProv
+		if err := json.Unmarshal(content, &sp); err != nil {
+			return nil, fmt.Errorf("%v: %w", p, err)
+		}
+		return admissionScoresFromSchoolProv(sp, p), nil
+	default:
+		return admissionScoresFromPythonScore(p, province)
+	}
+}
+
+// writeAdmissionScores adds records to a json lines file. the file keeps one record per
+// Key(), a record imported again replaces the earlier one in place.
+func writeAdmissionScores(file string, records []AdmissionScore) error {
+	existing, err := readAdmissionScores(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	index := make(map[string]int, len(existing)+len(records))
+	all := make([]AdmissionScore, 0, len(existing)+len(records))
+	for _, r := range append(existing, records...) {
+		if i, ok := index[r.Key()]; ok {
+			all[i] = r
+			continue
+		}
+		index[r.Key()] = len(all)
+		all = append(all, r)
+	}
+
+	// written aside and renamed, so a failed write keeps the old file
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	writer := bufio.NewWriter(f)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	for _, r := range all {
+		if err := encoder.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// readAdmissionScores reads a json lines file written by writeAdmissionScores.
+func readAdmissionScores(file string) ([]AdmissionScore, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	res := make([]AdmissionScore, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var a AdmissionScore
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", file, line, err)
+		}
+		res = append(res, a)
+	}
+	return res, scanner.Err()
+}
+
+// importCommand converts outputs of any implementation into canonical admission score records.
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	out := fs.String("out", admissionScoreFile, "canonical json lines file to add to, one record per key")
+	schoolList := fs.String("school-list", schoolListFile, "school list used to resolve school names and ids")
+	province := fs.String("province", legacyProvince, "province of python school_special_score files")
+	must(fs.Parse(args))
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: import [flags] <special_detail|part-*.jsonl|school_special_score|result.json>...")
+	}
+
+	schoolNameIDMap := loadSchoolNames(*schoolList)
+	for _, p := range fs.Args() {
+		records, err := importAdmissionScores(p, *province, schoolNameIDMap)
+		if err != nil {
+			return err
+		}
+		if err := writeAdmissionScores(*out, records); err != nil {
+			return err
+		}
+		log.Infow("imported", zap.String("path", p), zap.Int("records", len(records)))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestImportLegacyResultTwice(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	result := filepath.Join(dir, "result.json")
+	lines := []string{
+		// same major at two schools missing from the school list
+		`{"School":{"school_name":"甲大学"},"Year":2020,"Specific":{"data":{"item":[{"spname":"数学","zslx_name":"普通类","local_batch_name":"本科一批","min":"600","min_section":"3000"}]}}}`,
+		`{"School":{"school_name":"乙大学"},"Year":2020,"Specific":{"data":{"item":[{"spname":"数学","zslx_name":"普通类","local_batch_name":"本科一批","min":"590","min_section":"3500"}]}}}`,
+		// one major under two zslx names
+		`{"School":{"school_name":"甲大学"},"Year":2021,"Specific":{"data":{"item":[{"spname":"法学","zslx_name":"普通类","min":"610"},{"spname":"法学","zslx_name":"中外合作办学","min":"580"}]}}}`,
+		// schoolplanindex plans carry no score
+		`{"School":{"school_name":"甲大学"},"Year":2022,"Specific":{"data":{"item":[{"spname":"法学","zslx_name":"普通类"}]}}}`,
+	}
+	if err := os.WriteFile(result, []byte(strings.Join(lines, "\n")+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, admissionScoreFile)
+	for i := 0; i < 2; i++ {
+		records, err := importAdmissionScores(result, legacyProvince, map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 4 {
+			t.Fatalf("import %v: %v records, want 4", i, len(records))
+		}
+		if err := writeAdmissionScores(out, records); err != nil {
+			t.Fatal(err)
+		}
+		written, err := readAdmissionScores(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(written) != 4 {
+			t.Errorf("import %v: %v rows written, want 4", i, len(written))
+		}
+	}
+}
+
+func TestAdmissionScoreKey(t *testing.T) {
+	a := AdmissionScore{SchoolName: "甲大学", ProvinceID: "45", Year: 2020, SpecialName: "数学", ZslxName: "普通类"}
+	for name, b := range map[string]AdmissionScore{
+		"school name": {SchoolName: "乙大学", ProvinceID: "45", Year: 2020, SpecialName: "数学", ZslxName: "普通类"},
+		"zslx name":   {SchoolName: "甲大学", ProvinceID: "45", Year: 2020, SpecialName: "数学", ZslxName: "中外合作办学"},
+		"local batch": {SchoolName: "甲大学", ProvinceID: "45", Year: 2020, SpecialName: "数学", ZslxName: "普通类", LocalBatchName: "本科二批"},
+	} {
+		if a.Key() == b.Key() {
+			t.Errorf("records differing by %v share key %v", name, a.Key())
+		}
+	}
+	// the name only stands in for a missing id
+	x := AdmissionScore{SchoolID: "31", SchoolName: "北京大学", ProvinceID: "45", Year: 2020, SpecialName: "数学"}
+	y := x
+	y.SchoolName = ""
+	if x.Key() != y.Key() {
+		t.Errorf("school name changes the key of a resolved school: %v, %v", x.Key(), y.Key())
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"path"
+	"sort"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const majorCatalogFile = "major_catalog.json"
+
+// MajorCatalog is the deduplicated discipline tree of every crawled special. upstream
+// level1 is the education level (本科/专科), level2 the 门类 and level3 the 专业类.
+type MajorCatalog struct {
+	Majors int
+	Levels []*CatalogNode
+}
+
+// CatalogNode is a level of the tree, IDs are upstream level ids.
+type CatalogNode struct {
+	ID           string
+	Name         string
+	NameVariants []NameVariant
+	Children     []*CatalogNode  `json:",omitempty"`
+	Majors       []*CatalogMajor `json:",omitempty"`
+
+	children map[string]*CatalogNode
+	majors   map[string]*CatalogMajor
+	names    map[string]map[int]bool
+}
+
+// CatalogMajor is a 专业, its ID is the upstream special_id, or the name prefixed with
+// "name:" when upstream has none.
+type CatalogMajor struct {
+	ID           string
+	Name         string // name of the latest year
+	NameVariants []NameVariant
+	Schools      []CatalogSchool
+
+	names   map[string]map[int]bool
+	schools map[string]map[int]bool
+}
+
+// NameVariant is a name together with the years it was used.
+type NameVariant struct {
+	Name  string
+	Years []int
+}
+
+type CatalogSchool struct {
+	SchoolID   string
+	SchoolName string
+	Years      []int
+}
+
+func newCatalogNode(id string) *CatalogNode {
+	return &CatalogNode{
+		ID:       id,
+		children: make(map[string]*CatalogNode),
+		majors:   make(map[string]*CatalogMajor),
+		names:    make(map[string]map[int]bool),
+	}
+}
+
+func (n *CatalogNode) child(id, name string, year int) *CatalogNode {
+	c, ok := n.children[id]
+	if !ok {
+		c = newCatalogNode(id)
+		n.children[id] = c
+	}
+	addYear(c.names, name, year)
+	return c
+}
+
+func addYear(m map[string]map[int]bool, key string, year int) {
+	if key == "" {
+		return
+	}
+	if m[key] == nil {
+		m[key] = make(map[int]bool)
+	}
+	m[key][year] = true
+}
+
+func sortedYears(years map[int]bool) []int {
+	res := make([]int, 0, len(years))
+	for y := range years {
+		res = append(res, y)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// nameVariants returns names sorted by their first year, and the name of the latest year.
+func nameVariants(names map[string]map[int]bool) ([]NameVariant, string) {
+	res := make([]NameVariant, 0, len(names))
+	for name, years := range names {
+		res = append(res, NameVariant{Name: name, Years: sortedYears(years)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Years[0] != res[j].Years[0] {
+			return res[i].Years[0] < res[j].Years[0]
+		}
+		return res[i].Name < res[j].Name
+	})
+	latest, latestYear := "", 0
+	for _, v := range res {
+		if y := v.Years[len(v.Years)-1]; y >= latestYear {
+			latest, latestYear = v.Name, y
+		}
+	}
+	return res, latest
+}
+
+// add puts a special of a school and year into the tree.
+func (c *MajorCatalog) add(root *CatalogNode, s TypedSpecial, schoolID string, year int) {
+	node := root.child(s.Level1, s.Level1Name, year).
+		child(s.Level2, s.Level2Name, year).
+		child(s.Level3, s.Level3Name, year)
+	id := s.SpecialID
+	if id == "" || id == "0" {
+		id = "name:" + s.Spname
+	}
+	m, ok := node.majors[id]
+	if !ok {
+		m = &CatalogMajor{ID: id, names: make(map[string]map[int]bool), schools: make(map[string]map[int]bool)}
+		node.majors[id] = m
+	}
+	addYear(m.names, s.Spname, year)
+	addYear(m.schools, firstNonEmpty(s.SchoolID, schoolID), year)
+}
+
+// finish turns the maps of node into sorted slices, recursively.
+func (c *MajorCatalog) finish(n *CatalogNode) {
+	n.NameVariants, n.Name = nameVariants(n.names)
+	n.Children = make([]*CatalogNode, 0, len(n.children))
+	for _, child := range n.children {
+		c.finish(child)
+		n.Children = append(n.Children, child)
+	}
+	sort.Slice(n.Children, func(i, j int) bool { return lessID(n.Children[i].ID, n.Children[j].ID) })
+	n.Majors = make([]*CatalogMajor, 0, len(n.majors))
+	for _, m := range n.majors {
+		m.NameVariants, m.Name = nameVariants(m.names)
+		m.Schools = make([]CatalogSchool, 0, len(m.schools))
+		for id, years := range m.schools {
+			m.Schools = append(m.Schools, CatalogSchool{SchoolID: id, SchoolName: schoolIDNameMap[id], Years: sortedYears(years)})
+		}
+		sort.Slice(m.Schools, func(i, j int) bool { return lessID(m.Schools[i].SchoolID, m.Schools[j].SchoolID) })
+		n.Majors = append(n.Majors, m)
+	}
+	sort.Slice(n.Majors, func(i, j int) bool { return lessID(n.Majors[i].ID, n.Majors[j].ID) })
+	c.Majors += len(n.Majors)
+}
+
+// lessID orders numeric ids by value and other ids after them.
+func lessID(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return x < y
+	case errA == nil:
+		return true
+	case errB == nil:
+		return false
+	}
+	return a < b
+}
+
+// buildMajorCatalog builds the catalog from a special_detail directory.
+func buildMajorCatalog(dir string) (*MajorCatalog, error) {
+	c := &MajorCatalog{}
+	root := newCatalogNode("")
+	err := walkSpecialDetail(dir, nil, func(file string, sp SchoolProv) error {
+		for _, ytb := range sp.YTBSpecials {
+			year, _ := strconv.Atoi(ytb.Year)
+			for _, s := range ytb.Special {
+				c.add(root, s, sp.SchoolID, year)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.finish(root)
+	c.Levels = root.Children
+	return c, nil
+}
+
+// majorCatalogStage writes the major catalog of the special details under dir to out.
+func majorCatalogStage(dir, out string) error {
+	catalog, err := buildMajorCatalog(dir)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(out, catalog); err != nil {
+		return err
+	}
+	log.Infow("major catalog built", zap.Int("majors", catalog.Majors), zap.String("file", out))
+	return nil
+}
+
+// catalogCommand builds the major catalog of an existing crawl output.
+func catalogCommand(args []string) error {
+	fs := flag.NewFlagSet("catalog", flag.ExitOnError)
+	dir := fs.String("dir", ".", "crawl output directory")
+	out := fs.String("out", majorCatalogFile, "catalog file")
+	must(fs.Parse(args))
+	loadSchoolNames(path.Join(*dir, schoolListFile))
+	return majorCatalogStage(path.Join(*dir, specialDetailDir), *out)
+}
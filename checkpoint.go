@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const checkpointFile = "checkpoint.json"
+
+// checkpoint remembers finished work items per stage, so a run stopped by its budget
+// resumes where it left off. it is removed once a run completes.
+type checkpoint struct {
+	mu   sync.Mutex
+	done map[string]map[string]bool // stage -> key -> done
+}
+
+func loadCheckpoint() *checkpoint {
+	cp := &checkpoint{done: make(map[string]map[string]bool)}
+	content, err := os.ReadFile(checkpointFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatalw("read checkpoint failed", zap.Error(err))
+		}
+		return cp
+	}
+	stages := make(map[string][]string)
+	if err := json.Unmarshal(content, &stages); err != nil {
+		log.Fatalw("unmarshal checkpoint failed", zap.Error(err))
+	}
+	for stage, keys := range stages {
+		for _, key := range keys {
+			cp.markDone(stage, key)
+		}
+	}
+	log.Infow("checkpoint loaded, resuming", zap.Int("stages", len(stages)))
+	return cp
+}
+
+func (c *checkpoint) isDone(stage, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[stage][key]
+}
+
+func (c *checkpoint) markDone(stage, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done[stage] == nil {
+		c.done[stage] = make(map[string]bool)
+	}
+	c.done[stage][key] = true
+}
+
+// started tells whether any item of the stage was finished by a previous run.
+func (c *checkpoint) started(stage string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done[stage]) != 0
+}
+
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	stages := make(map[string][]string, len(c.done))
+	for stage, keys := range c.done {
+		for key := range keys {
+			stages[stage] = append(stages[stage], key)
+		}
+		sort.Strings(stages[stage])
+	}
+	c.mu.Unlock()
+	content, err := json.MarshalIndent(stages, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(checkpointFile, content, 0666)
+}
+
+func (c *checkpoint) remove() error {
+	if err := os.Remove(checkpointFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// inTempDir runs the test in a temporary working directory, the crawl writes relative paths.
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	inTempDir(t)
+	cp := loadCheckpoint()
+	if cp.started(stageSchoolInfo) {
+		t.Fatal("fresh checkpoint has started stages")
+	}
+	cp.markDone(stageSchoolInfo, "31")
+	cp.markDone(stageSpecialDetail, "31_45")
+	if err := cp.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := loadCheckpoint()
+	if !resumed.isDone(stageSchoolInfo, "31") || !resumed.isDone(stageSpecialDetail, "31_45") {
+		t.Error("finished items lost")
+	}
+	if resumed.isDone(stageSchoolInfo, "32") {
+		t.Error("unfinished item done")
+	}
+	if !resumed.started(stageSpecialDetail) || resumed.started(stageSchoolPTB) {
+		t.Error("started stages wrong")
+	}
+
+	if err := resumed.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(checkpointFile); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not removed: %v", err)
+	}
+	if err := resumed.remove(); err != nil {
+		t.Errorf("removing a missing checkpoint: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// command is a subcommand of gk-score, args exclude the command name.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+// commands available besides the default crawl.
+var commands = map[string]command{
+	"catalog":       {"build the major catalog (门类 → 专业类 → 专业) of a crawl output", catalogCommand},
+	"diff":          {"report schools, majors, years and scores that changed between two crawl outputs", diffCommand},
+	"export":        {"export a province as a flattened csv (utf-8 with bom) and xlsx table for counselors", exportCommand},
+	"groups":        {"derive 专业组 aggregates (special_group/) from special_detail/", groupsCommand},
+	"import":        {"convert special_detail, legacy result.json and python outputs into canonical records", importCommand},
+	"unknown-codes": {"list province/type/batch codes of a crawl missing from the dictionaries", unknownCodesCommand},
+	"import-legacy": {"load outputs of the test/ scripts into a crawl output tree with provenance, partial records marked", importLegacyCommand},
+	"merge":         {"merge partial crawl outputs record by record, keeping the most complete or newest version", mergeCommand},
+	"parquet":       {"export special details and schools as parquet, partitioned by year and province", parquetCommand},
+	"query":         {"look up scores and ranks of a snapshot by school, major, province and years through an index", queryCommand},
+	"recommend":     {"classify specials into reach, match and safety (冲/稳/保) for a student rank by their rank history", recommendCommand},
+	"sqlite":        {"export a crawl output into one SQLite file with normalized tables", sqliteCommand},
+	"validate":      {"check a crawl output tree against the published json schemas", validateCommand},
+}
+
+// runCommand runs the subcommand named by the first argument. it returns false
+// when there is none, which means crawl.
+func runCommand() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	name := os.Args[1]
+	if name == "crawl" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		return false
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return true
+	}
+	cmd, ok := commands[name]
+	if !ok && strings.HasPrefix(name, "-") {
+		// flags of the default crawl
+		return false
+	}
+	if !ok {
+		// a mistyped command must not start a crawl
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		log.Errorw("command failed", zap.String("command", name), zap.Error(err))
+		os.Exit(1)
+	}
+	return true
+}
+
+func usage() {
+	fmt.Println("usage: gk-score [crawl] [flags]")
+	fmt.Println("       gk-score <command> [flags]")
+	fmt.Println("commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-16v %v\n", name, commands[name].usage)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// walkSpecialDetail decodes the special_detail records under dir, of either sink, in key
+// order. keep, when set, picks the <school>_<province> keys to decode.
+func walkSpecialDetail(dir string, keep func(key string) bool, fn func(source string, sp SchoolProv) error) error {
+	return readDataset(dir, keep, func(rec datasetRecord) error {
+		var sp SchoolProv
+		if err := json.Unmarshal(rec.Data, &sp); err != nil {
+			return fmt.Errorf("%v: %w", rec.Source, err)
+		}
+		return fn(rec.Source, sp)
+	})
+}
+
+// datasetKeys lists the keys of the records under dir without decoding them, a missing
+// dir has none.
+func datasetKeys(dir string) ([]string, error) {
+	keys := make([]string, 0)
+	err := readDataset(dir, func(key string) bool {
+		keys = append(keys, key)
+		return false
+	}, nil)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	return keys, err
+}
+
+// provinceOfKey returns the province of a <school>_<province> key.
+func provinceOfKey(key string) string {
+	return key[strings.LastIndex(key, "_")+1:]
+}
+
+// writeJSON writes v pretty printed, like every other output. upstream text is kept
+// as is, & < > are not escaped.
+func writeJSON(file string, v interface{}) error {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0666)
+}
+
+// loadSchoolProfiles reads school_info/ of either sink into id -> profile, a missing dir gives none.
+func loadSchoolProfiles(dir string) (map[string]SchoolProfile, error) {
+	res := make(map[string]SchoolProfile)
+	err := readDataset(dir, nil, func(rec datasetRecord) error {
+		var p SchoolProfile
+		if err := json.Unmarshal(rec.Data, &p); err != nil {
+			return fmt.Errorf("%v: %w", rec.Source, err)
+		}
+		if p.SchoolID == "" {
+			// <id>_<name>
+			p.SchoolID = strings.SplitN(rec.Key, "_", 2)[0]
+		}
+		res[p.SchoolID] = p
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	return res, err
+}
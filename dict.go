@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// dictionaries of upstream codes, shipped within the binary. bump the version of a
+// dictionary file whenever its codes change.
+//
+//go:embed dict/*.json
+var dictFS embed.FS
+
+var (
+	provinceDict = mustLoadDict("province")
+	typeDict     = mustLoadDict("type")
+	batchDict    = mustLoadDict("batch")
+)
+
+type CodeName struct {
+	ZH string `json:"zh"`
+	EN string `json:"en"`
+}
+
+type codeDict struct {
+	Kind    string              `json:"kind"`
+	Version string              `json:"version"`
+	Codes   map[string]CodeName `json:"codes"`
+	// zh and lower case en names -> code, a name shared by codes goes to the lowest
+	byName map[string]string
+}
+
+func mustLoadDict(kind string) *codeDict {
+	content, err := dictFS.ReadFile(path.Join("dict", kind+".json"))
+	if err != nil {
+		panic(err)
+	}
+	var d codeDict
+	if err := json.Unmarshal(content, &d); err != nil {
+		panic(fmt.Sprintf("dictionary %v: %v", kind, err))
+	}
+	d.index()
+	return &d
+}
+
+// index builds the reverse index of names, visiting codes in order so it does not
+// depend on map iteration.
+func (d *codeDict) index() {
+	codes := make([]string, 0, len(d.Codes))
+	for code := range d.Codes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return lessID(codes[i], codes[j]) })
+	d.byName = make(map[string]string, 2*len(codes))
+	for _, code := range codes {
+		n := d.Codes[code]
+		for _, name := range []string{n.ZH, strings.ToLower(n.EN)} {
+			if _, ok := d.byName[name]; !ok && name != "" {
+				d.byName[name] = code
+			}
+		}
+	}
+}
+
+// name returns the names of code, an unknown code gets the code itself as its names.
+func (d *codeDict) name(code string) CodeName {
+	if n, ok := d.Codes[code]; ok {
+		return n
+	}
+	return CodeName{ZH: code, EN: code}
+}
+
+// code returns the code named s in either language, s itself when it is a code or unknown.
+func (d *codeDict) code(s string) string {
+	if _, ok := d.Codes[s]; ok {
+		return s
+	}
+	if code, ok := d.byName[s]; ok {
+		return code
+	}
+	if code, ok := d.byName[strings.ToLower(s)]; ok {
+		return code
+	}
+	return s
+}
+
+func (d *codeDict) known(code string) bool {
+	_, ok := d.Codes[code]
+	return ok
+}
+
+// unknownCodesCommand lists the province, type and batch codes of a crawl output which
+// are not in the dictionaries, with the number of ptb records using them.
+func unknownCodesCommand(args []string) error {
+	fs := flag.NewFlagSet("unknown-codes", flag.ExitOnError)
+	dir := fs.String("dir", ".", "crawl output directory")
+	must(fs.Parse(args))
+
+	f, err := os.Open(path.Join(*dir, schoolPTBFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// kind -> code -> records
+	unknown := map[string]map[string]int{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// year, school, prov, type, batch
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 5 {
+			continue
+		}
+		for _, c := range []struct {
+			d    *codeDict
+			code string
+		}{{provinceDict, fields[2]}, {typeDict, fields[3]}, {batchDict, fields[4]}} {
+			if c.d.known(c.code) {
+				continue
+			}
+			if unknown[c.d.Kind] == nil {
+				unknown[c.d.Kind] = map[string]int{}
+			}
+			unknown[c.d.Kind][c.code]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, d := range []*codeDict{provinceDict, typeDict, batchDict} {
+		codes := make([]string, 0, len(unknown[d.Kind]))
+		for code := range unknown[d.Kind] {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		fmt.Printf("%v (dictionary %v): %v unknown\n", d.Kind, d.Version, len(codes))
+		for _, code := range codes {
+			fmt.Printf("  %v\t%v records\n", code, unknown[d.Kind][code])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestDictCode(t *testing.T) {
+	for s, want := range map[string]string{
+		"2073":          "2073",
+		"物理类":           "2073",
+		"physics track": "2073",
+		"Physics Track": "2073",
+		"理科":            "1",
+		"unknown":       "unknown",
+		"9999":          "9999",
+	} {
+		if got := typeDict.code(s); got != want {
+			t.Errorf("%q: got %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestDictCodeSharedName(t *testing.T) {
+	d := &codeDict{Codes: map[string]CodeName{
+		"10": {ZH: "本科批", EN: "Undergraduate"},
+		"7":  {ZH: "本科一批", EN: "Undergraduate"},
+		"14": {ZH: "本科批", EN: "Regular"},
+	}}
+	d.index()
+	// the lowest code wins, whatever the map order
+	for i := 0; i < 20; i++ {
+		if got := d.code("本科批"); got != "10" {
+			t.Fatalf("本科批: got %v, want 10", got)
+		}
+		if got := d.code("undergraduate"); got != "7" {
+			t.Fatalf("undergraduate: got %v, want 7", got)
+		}
+	}
+}
+
+func TestDictName(t *testing.T) {
+	if n := provinceDict.name("45"); n.ZH == "45" {
+		t.Errorf("province 45 unnamed: %+v", n)
+	}
+	if n := batchDict.name("9999"); n.ZH != "9999" || n.EN != "9999" {
+		t.Errorf("unknown code named %+v", n)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bzssm/goclub/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const snapshotDiffFile = "diff.json"
+
+// SnapshotDiff is the change set between two crawl outputs, from A to B.
+type SnapshotDiff struct {
+	A, B           string
+	CreatedAt      time.Time
+	ScoreThreshold float64 // min, average and max changes above it are reported
+	RankThreshold  int64   // min rank changes above it are reported
+
+	SchoolsAdded   []schoolData
+	SchoolsRemoved []schoolData
+	Years          []YearChange
+	Majors         []MajorChange
+	Scores         []ScoreChange
+}
+
+// YearChange lists the years of a province that appeared or vanished.
+type YearChange struct {
+	ProvinceID string
+	Added      []int `json:",omitempty"`
+	Removed    []int `json:",omitempty"`
+}
+
+// MajorChange lists the majors a school offers in a province, in any year, that
+// appeared or vanished.
+type MajorChange struct {
+	SchoolID   string
+	SchoolName string
+	ProvinceID string
+	Added      []DiffMajor `json:",omitempty"`
+	Removed    []DiffMajor `json:",omitempty"`
+}
+
+type DiffMajor struct {
+	SpecialID   string
+	SpecialName string
+}
+
+// ScoreChange is a special in both snapshots whose scores or min rank moved.
+type ScoreChange struct {
+	SchoolID    string
+	SchoolName  string
+	ProvinceID  string
+	Year        int
+	Type        string
+	Batch       string
+	SpecialID   string
+	SpecialName string
+	Changes     []FieldChange
+}
+
+type FieldChange struct {
+	Field  string
+	Before float64
+	After  float64
+	Delta  float64
+}
+
+// loadSchoolList reads a school_list.json, a missing file has no schools.
+func loadSchoolList(file string) ([]schoolData, error) {
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list school
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, fmt.Errorf("%v: %w", file, err)
+	}
+	return list.Data, nil
+}
+
+// diffSchools compares the school lists of both snapshots and fills schoolIDNameMap,
+// names of B win.
+func (d *SnapshotDiff) diffSchools() error {
+	a, err := loadSchoolList(path.Join(d.A, schoolListFile))
+	if err != nil {
+		return err
+	}
+	b, err := loadSchoolList(path.Join(d.B, schoolListFile))
+	if err != nil {
+		return err
+	}
+	inA, inB := make(map[string]bool, len(a)), make(map[string]bool, len(b))
+	for _, sc := range a {
+		inA[sc.SchoolID] = true
+		schoolIDNameMap[sc.SchoolID] = sc.Name
+	}
+	for _, sc := range b {
+		inB[sc.SchoolID] = true
+		schoolIDNameMap[sc.SchoolID] = sc.Name
+	}
+	for _, sc := range b {
+		if !inA[sc.SchoolID] {
+			d.SchoolsAdded = append(d.SchoolsAdded, sc)
+		}
+	}
+	for _, sc := range a {
+		if !inB[sc.SchoolID] {
+			d.SchoolsRemoved = append(d.SchoolsRemoved, sc)
+		}
+	}
+	byID := func(s []schoolData) func(i, j int) bool {
+		return func(i, j int) bool { return lessID(s[i].SchoolID, s[j].SchoolID) }
+	}
+	sort.Slice(d.SchoolsAdded, byID(d.SchoolsAdded))
+	sort.Slice(d.SchoolsRemoved, byID(d.SchoolsRemoved))
+	return nil
+}
+
+// provinceScores reads the admission scores of a province from a snapshot by key.
+func provinceScores(dir, province string) (map[string]AdmissionScore, error) {
+	res := make(map[string]AdmissionScore)
+	onlyProvince := func(key string) bool { return provinceOfKey(key) == province }
+	err := walkSpecialDetail(path.Join(dir, specialDetailDir), onlyProvince, func(file string, sp SchoolProv) error {
+		for _, a := range admissionScoresFromSchoolProv(sp, file) {
+			res[a.Key()] = a
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	return res, err
+}
+
+// diffSpecials compares special_detail/ of both snapshots one province at a time, so
+// only one province of each is held in memory.
+func (d *SnapshotDiff) diffSpecials() error {
+	seen := make(map[string]bool)
+	provinces := make([]string, 0)
+	for _, dir := range []string{d.A, d.B} {
+		keys, err := datasetKeys(path.Join(dir, specialDetailDir))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if prov := provinceOfKey(key); !seen[prov] {
+				seen[prov] = true
+				provinces = append(provinces, prov)
+			}
+		}
+	}
+	sort.Slice(provinces, func(i, j int) bool { return lessID(provinces[i], provinces[j]) })
+	for _, prov := range provinces {
+		a, err := provinceScores(d.A, prov)
+		if err != nil {
+			return err
+		}
+		b, err := provinceScores(d.B, prov)
+		if err != nil {
+			return err
+		}
+		d.diffProvince(prov, a, b)
+	}
+	return nil
+}
+
+func (d *SnapshotDiff) diffProvince(prov string, a, b map[string]AdmissionScore) {
+	// years of the province
+	yearsA, yearsB := make(map[int]bool), make(map[int]bool)
+	// school -> major -> name
+	majorsA, majorsB := make(map[string]map[DiffMajor]bool), make(map[string]map[DiffMajor]bool)
+	collect := func(scores map[string]AdmissionScore, years map[int]bool, majors map[string]map[DiffMajor]bool) {
+		for _, s := range scores {
+			years[s.Year] = true
+			if majors[s.SchoolID] == nil {
+				majors[s.SchoolID] = make(map[DiffMajor]bool)
+			}
+			majors[s.SchoolID][DiffMajor{SpecialID: s.SpecialID, SpecialName: s.SpecialName}] = true
+		}
+	}
+	collect(a, yearsA, majorsA)
+	collect(b, yearsB, majorsB)
+
+	years := YearChange{ProvinceID: prov}
+	for y := range yearsB {
+		if !yearsA[y] {
+			years.Added = append(years.Added, y)
+		}
+	}
+	for y := range yearsA {
+		if !yearsB[y] {
+			years.Removed = append(years.Removed, y)
+		}
+	}
+	if len(years.Added)+len(years.Removed) != 0 {
+		sort.Ints(years.Added)
+		sort.Ints(years.Removed)
+		d.Years = append(d.Years, years)
+	}
+
+	schoolIDs := make([]string, 0)
+	for id := range majorsA {
+		schoolIDs = append(schoolIDs, id)
+	}
+	for id := range majorsB {
+		if majorsA[id] == nil {
+			schoolIDs = append(schoolIDs, id)
+		}
+	}
+	sort.Slice(schoolIDs, func(i, j int) bool { return lessID(schoolIDs[i], schoolIDs[j]) })
+	for _, id := range schoolIDs {
+		change := MajorChange{SchoolID: id, SchoolName: schoolIDNameMap[id], ProvinceID: prov}
+		for m := range majorsB[id] {
+			if !majorsA[id][m] {
+				change.Added = append(change.Added, m)
+			}
+		}
+		for m := range majorsA[id] {
+			if !majorsB[id][m] {
+				change.Removed = append(change.Removed, m)
+			}
+		}
+		if len(change.Added)+len(change.Removed) == 0 {
+			continue
+		}
+		sortMajors(change.Added)
+		sortMajors(change.Removed)
+		d.Majors = append(d.Majors, change)
+	}
+
+	scores := make([]ScoreChange, 0)
+	for key, after := range b {
+		before, ok := a[key]
+		if !ok {
+			continue
+		}
+		changes := make([]FieldChange, 0)
+		for _, f := range []struct {
+			name          string
+			before, after NullDecimal
+		}{
+			{"min", before.Min, after.Min},
+			{"average", before.Average, after.Average},
+			{"max", before.Max, after.Max},
+		} {
+			// a score missing on either side has nothing to compare
+			if f.before.Valid && f.after.Valid && math.Abs(f.after.Float-f.before.Float) > d.ScoreThreshold {
+				changes = append(changes, FieldChange{Field: f.name, Before: f.before.Float, After: f.after.Float, Delta: f.after.Float - f.before.Float})
+			}
+		}
+		if before.MinSection.Valid && after.MinSection.Valid {
+			if delta := after.MinSection.Int - before.MinSection.Int; delta > d.RankThreshold || -delta > d.RankThreshold {
+				changes = append(changes, FieldChange{Field: "min_section", Before: float64(before.MinSection.Int), After: float64(after.MinSection.Int), Delta: float64(delta)})
+			}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		scores = append(scores, ScoreChange{
+			SchoolID:    after.SchoolID,
+			SchoolName:  after.SchoolName,
+			ProvinceID:  prov,
+			Year:        after.Year,
+			Type:        after.Type,
+			Batch:       after.Batch,
+			SpecialID:   after.SpecialID,
+			SpecialName: after.SpecialName,
+			Changes:     changes,
+		})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		x, y := scores[i], scores[j]
+		if x.Year != y.Year {
+			return x.Year > y.Year
+		}
+		if x.SchoolID != y.SchoolID {
+			return lessID(x.SchoolID, y.SchoolID)
+		}
+		if x.Type+x.Batch != y.Type+y.Batch {
+			return x.Type+x.Batch < y.Type+y.Batch
+		}
+		return x.SpecialName < y.SpecialName
+	})
+	d.Scores = append(d.Scores, scores...)
+}
+
+func sortMajors(m []DiffMajor) {
+	sort.Slice(m, func(i, j int) bool {
+		if m[i].SpecialName != m[j].SpecialName {
+			return m[i].SpecialName < m[j].SpecialName
+		}
+		return m[i].SpecialID < m[j].SpecialID
+	})
+}
+
+// writeText prints the change set for people, at most limit lines per section.
+func (d *SnapshotDiff) writeText(w io.Writer, limit int) {
+	section := func(title string, n int, line func(i int) string) {
+		fmt.Fprintf(w, "%v: %v\n", title, n)
+		for i := 0; i < n; i++ {
+			if limit > 0 && i == limit {
+				fmt.Fprintf(w, "  ... %v more\n", n-limit)
+				break
+			}
+			fmt.Fprintf(w, "  %v\n", line(i))
+		}
+	}
+	fmt.Fprintf(w, "diff %v -> %v\n", d.A, d.B)
+	section("schools added", len(d.SchoolsAdded), func(i int) string {
+		return fmt.Sprintf("+ %v %v", d.SchoolsAdded[i].SchoolID, d.SchoolsAdded[i].Name)
+	})
+	section("schools removed", len(d.SchoolsRemoved), func(i int) string {
+		return fmt.Sprintf("- %v %v", d.SchoolsRemoved[i].SchoolID, d.SchoolsRemoved[i].Name)
+	})
+	section("provinces with year changes", len(d.Years), func(i int) string {
+		y := d.Years[i]
+		parts := make([]string, 0, len(y.Added)+len(y.Removed))
+		for _, year := range y.Added {
+			parts = append(parts, fmt.Sprintf("+%v", year))
+		}
+		for _, year := range y.Removed {
+			parts = append(parts, fmt.Sprintf("-%v", year))
+		}
+		return fmt.Sprintf("%v: %v", provinceDict.name(y.ProvinceID).ZH, strings.Join(parts, " "))
+	})
+	section("schools with major changes", len(d.Majors), func(i int) string {
+		m := d.Majors[i]
+		parts := make([]string, 0, len(m.Added)+len(m.Removed))
+		for _, major := range m.Added {
+			parts = append(parts, "+"+major.SpecialName)
+		}
+		for _, major := range m.Removed {
+			parts = append(parts, "-"+major.SpecialName)
+		}
+		return fmt.Sprintf("%v %v / %v: %v", m.SchoolID, m.SchoolName, provinceDict.name(m.ProvinceID).ZH, strings.Join(parts, " "))
+	})
+	section(fmt.Sprintf("score changes (score > %v, rank > %v)", d.ScoreThreshold, d.RankThreshold), len(d.Scores), func(i int) string {
+		s := d.Scores[i]
+		parts := make([]string, 0, len(s.Changes))
+		for _, c := range s.Changes {
+			parts = append(parts, fmt.Sprintf("%v %v -> %v (%+g)", c.Field, c.Before, c.After, c.Delta))
+		}
+		return fmt.Sprintf("%v %v %v / %v %v %v %v: %v", s.Year, s.SchoolID, s.SchoolName,
+			provinceDict.name(s.ProvinceID).ZH, typeDict.name(s.Type).ZH, batchDict.name(s.Batch).ZH,
+			s.SpecialName, strings.Join(parts, ", "))
+	})
+}
+
+// diffCommand reports what changed between two crawl outputs.
+func diffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	scoreThreshold := fs.Float64("score-threshold", 1, "report min, average and max changes above it")
+	rankThreshold := fs.Int64("rank-threshold", 100, "report min rank changes above it")
+	out := fs.String("out", snapshotDiffFile, "json change set file, empty skips it")
+	limit := fs.Int("limit", 50, "max printed lines per section, 0 means unlimited")
+	must(fs.Parse(args))
+	// logs go to stderr, so the report can be piped
+	if lgr, _, err := logger.InitLogger(zapcore.InfoLevel, true, "stderr"); err == nil {
+		log = lgr
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: diff [flags] <snapshotA> <snapshotB>")
+	}
+	d := &SnapshotDiff{
+		A:              fs.Arg(0),
+		B:              fs.Arg(1),
+		CreatedAt:      time.Now(),
+		ScoreThreshold: *scoreThreshold,
+		RankThreshold:  *rankThreshold,
+		SchoolsAdded:   make([]schoolData, 0),
+		SchoolsRemoved: make([]schoolData, 0),
+		Years:          make([]YearChange, 0),
+		Majors:         make([]MajorChange, 0),
+		Scores:         make([]ScoreChange, 0),
+	}
+	for _, dir := range []string{d.A, d.B} {
+		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+			return fmt.Errorf("snapshot %v is not a directory", dir)
+		}
+	}
+	if err := d.diffSchools(); err != nil {
+		return err
+	}
+	if err := d.diffSpecials(); err != nil {
+		return err
+	}
+	d.writeText(os.Stdout, *limit)
+	if *out != "" {
+		if err := writeJSON(*out, d); err != nil {
+			return err
+		}
+		log.Infow("change set written", zap.String("file", *out))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffProvinceThresholds(t *testing.T) {
+	score := func(special string, min float64, rank int64) AdmissionScore {
+		return AdmissionScore{
+			SchoolID: "31", ProvinceID: "45", Year: 2023, Type: "1", Batch: "7", SpecialName: special,
+			Min: NullDecimal{min, true}, MinSection: NullInt{rank, true},
+		}
+	}
+	before := []AdmissionScore{
+		score("数学", 600, 3000),
+		score("物理", 600, 3000),
+		score("化学", 600, 3000),
+		score("生物", 600, 3000),
+		score("法学", 600, 3000),
+	}
+	after := []AdmissionScore{
+		score("数学", 601, 3100), // both at the threshold, not reported
+		score("物理", 598, 3000), // score below the threshold
+		score("化学", 600, 2899), // rank above the threshold
+		score("生物", 0, 3000),   // score missing
+		score("经济学", 600, 3000),
+	}
+	after[3].Min = NullDecimal{}
+	byKey := func(scores []AdmissionScore) map[string]AdmissionScore {
+		res := make(map[string]AdmissionScore)
+		for _, s := range scores {
+			res[s.Key()] = s
+		}
+		return res
+	}
+	d := &SnapshotDiff{ScoreThreshold: 1, RankThreshold: 100}
+	d.diffProvince("45", byKey(before), byKey(after))
+
+	changed := make(map[string][]FieldChange)
+	for _, c := range d.Scores {
+		changed[c.SpecialName] = c.Changes
+	}
+	want := map[string][]FieldChange{
+		"物理": {{Field: "min", Before: 600, After: 598, Delta: -2}},
+		"化学": {{Field: "min_section", Before: 3000, After: 2899, Delta: -101}},
+	}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("score changes %+v, want %+v", changed, want)
+	}
+	if len(d.Majors) != 1 || !reflect.DeepEqual(d.Majors[0].Added, []DiffMajor{{SpecialName: "经济学"}}) ||
+		!reflect.DeepEqual(d.Majors[0].Removed, []DiffMajor{{SpecialName: "法学"}}) {
+		t.Errorf("major changes %+v", d.Majors)
+	}
+	if len(d.Years) != 0 {
+		t.Errorf("year changes %+v", d.Years)
+	}
+
+	// a zero threshold reports every move
+	d = &SnapshotDiff{}
+	d.diffProvince("45", byKey(before[:1]), byKey(after[:1]))
+	if len(d.Scores) != 1 || len(d.Scores[0].Changes) != 2 {
+		t.Errorf("zero thresholds: %+v", d.Scores)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const schemaDriftFile = "schema_drift.json"
+
+// drift modes
+const (
+	driftOff  = "off"
+	driftWarn = "warn"
+	driftFail = "fail"
+)
+
+// drift kinds
+const (
+	driftNew         = "new"
+	driftMissing     = "missing"
+	driftTypeChanged = "type_changed"
+)
+
+// maxDriftSamples is how many sample urls are kept per finding.
+const maxDriftSamples = 3
+
+// driftDetector compares raw upstream payloads with the fields the structs in schema.go
+// decode, so new, missing and retyped upstream fields no longer vanish silently.
+type driftDetector struct {
+	mode  string
+	known map[string]map[string]string // endpoint -> field path -> json type
+
+	mu       sync.Mutex
+	findings map[string]*driftFinding // endpoint|kind|path|got -> finding
+	// optional fields are absent from single items, a known field is only missing when
+	// no object of its path held it during the whole run, which is decided on save.
+	absent  map[string]*driftFinding // endpoint|path -> objects lacking the field
+	present map[string]bool          // endpoint|path
+}
+
+type driftFinding struct {
+	Endpoint   string
+	Path       string
+	Kind       string
+	Expected   string `json:",omitempty"`
+	Got        string `json:",omitempty"`
+	Count      int
+	SampleURLs []string
+}
+
+func newDriftDetector(mode string) *driftDetector {
+	d := &driftDetector{
+		mode:     mode,
+		known:    make(map[string]map[string]string),
+		findings: make(map[string]*driftFinding),
+		absent:   make(map[string]*driftFinding),
+		present:  make(map[string]bool),
+	}
+	for _, e := range endpoints {
+		d.register(e.Stage, e.Response)
+	}
+	return d
+}
+
+// register derives the known field set of an endpoint from the struct its response decodes into.
+func (d *driftDetector) register(endpoint string, v interface{}) {
+	fields := make(map[string]string)
+	knownFields(reflect.TypeOf(v), "", fields)
+	d.known[endpoint] = fields
+}
+
+// knownFields walks t and records json path -> json type, "[]" marks array elements and
+// "{}" the values of a map, whose keys are data, e.g. school ids.
+func knownFields(t reflect.Type, prefix string, fields map[string]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct && reflect.PtrTo(t).Implements(unmarshalerType) {
+		// decodes itself, e.g. json.RawMessage, its content is not known
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" || (name == "" && !f.IsExported()) {
+				continue
+			}
+			if f.Anonymous && name == "" {
+				knownFields(f.Type, prefix, fields)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			p := joinPath(prefix, name)
+			fields[p] = jsonKind(f.Type)
+			knownFields(f.Type, p, fields)
+		}
+	case reflect.Slice, reflect.Array:
+		p := prefix + "[]"
+		fields[p] = jsonKind(t.Elem())
+		knownFields(t.Elem(), p, fields)
+	case reflect.Map:
+		p := joinPath(prefix, mapValues)
+		fields[p] = jsonKind(t.Elem())
+		knownFields(t.Elem(), p, fields)
+	}
+}
+
+// mapValues is the path segment of the values of a map.
+const mapValues = "{}"
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		// decodes itself, whatever json type it accepts
+		return "any"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "any"
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// observe checks one raw payload of endpoint against its known fields.
+func (d *driftDetector) observe(endpoint, url string, content []byte) {
+	if d.mode == driftOff {
+		return
+	}
+	known, ok := d.known[endpoint]
+	if !ok {
+		return
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(content, &payload); err != nil {
+		// reported by the caller's own unmarshal
+		return
+	}
+	// only data is decoded, other top level fields are the response envelope
+	if data, ok := payload["data"]; ok {
+		d.walk(endpoint, url, known, "data", data)
+	} else {
+		d.field(endpoint, url, "data", known["data"], false)
+	}
+}
+
+func (d *driftDetector) walk(endpoint, url string, known map[string]string, prefix string, v interface{}) {
+	if known[prefix] == "any" && !hasChildren(known, prefix) {
+		// decodes itself, e.g. json.RawMessage, its content is not known
+		return
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if expected, ok := known[joinPath(prefix, mapValues)]; ok {
+			// keys are data, every value has the same schema
+			p := joinPath(prefix, mapValues)
+			for _, child := range val {
+				if got := payloadKind(child); got != "null" && expected != "any" && got != expected {
+					d.add(endpoint, url, driftTypeChanged, p, expected, got)
+					continue
+				}
+				d.walk(endpoint, url, known, p, child)
+			}
+			return
+		}
+		for name, child := range val {
+			p := joinPath(prefix, name)
+			expected, ok := known[p]
+			if !ok {
+				d.add(endpoint, url, driftNew, p, "", payloadKind(child))
+				continue
+			}
+			if got := payloadKind(child); got != "null" && expected != "any" && got != expected {
+				d.add(endpoint, url, driftTypeChanged, p, expected, got)
+				continue
+			}
+			d.walk(endpoint, url, known, p, child)
+		}
+		// known direct children absent from this object
+		for p, expected := range known {
+			name, ok := directChild(prefix, p)
+			if !ok {
+				continue
+			}
+			_, ok = val[name]
+			d.field(endpoint, url, p, expected, ok)
+		}
+	case []interface{}:
+		p := prefix + "[]"
+		expected := known[p]
+		for _, child := range val {
+			if got := payloadKind(child); got != "null" && expected != "any" && got != expected {
+				d.add(endpoint, url, driftTypeChanged, p, expected, got)
+				continue
+			}
+			d.walk(endpoint, url, known, p, child)
+		}
+	}
+}
+
+// hasChildren tells whether any known path is below prefix.
+func hasChildren(known map[string]string, prefix string) bool {
+	for p := range known {
+		if strings.HasPrefix(p, prefix+".") || strings.HasPrefix(p, prefix+"[]") {
+			return true
+		}
+	}
+	return false
+}
+
+// directChild returns the field name when path p is a field of the object at prefix.
+func directChild(prefix, p string) (string, bool) {
+	rest := p
+	if prefix != "" {
+		if !strings.HasPrefix(p, prefix+".") {
+			return "", false
+		}
+		rest = p[len(prefix)+1:]
+	}
+	return rest, rest != "" && !strings.ContainsAny(rest, ".[")
+}
+
+func payloadKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "any"
+}
+
+func (d *driftDetector) add(endpoint, url, kind, p, expected, got string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := endpoint + "|" + kind + "|" + p + "|" + got
+	f, ok := d.findings[key]
+	if !ok {
+		f = &driftFinding{Endpoint: endpoint, Path: p, Kind: kind, Expected: expected, Got: got}
+		d.findings[key] = f
+		log.Warnw("upstream schema drift",
+			zap.String("endpoint", endpoint),
+			zap.String("kind", kind),
+			zap.String("path", p),
+			zap.String("url", url))
+	}
+	f.Count++
+	if len(f.SampleURLs) < maxDriftSamples {
+		f.SampleURLs = append(f.SampleURLs, url)
+	}
+}
+
+// field records whether an object held the known field p.
+func (d *driftDetector) field(endpoint, url, p, expected string, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := endpoint + "|" + p
+	if ok {
+		d.present[key] = true
+		delete(d.absent, key)
+		return
+	}
+	if d.present[key] {
+		return
+	}
+	f, found := d.absent[key]
+	if !found {
+		f = &driftFinding{Endpoint: endpoint, Path: p, Kind: driftMissing, Expected: expected}
+		d.absent[key] = f
+	}
+	f.Count++
+	if len(f.SampleURLs) < maxDriftSamples {
+		f.SampleURLs = append(f.SampleURLs, url)
+	}
+}
+
+// count returns the number of distinct findings, missing fields included.
+func (d *driftDetector) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.findings) + len(d.absent)
+}
+
+// failed tells whether the run has to stop because of drift. only new and retyped
+// fields fail a run, missing ones are not known before its end.
+func (d *driftDetector) failed() bool {
+	if d.mode != driftFail {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.findings) != 0
+}
+
+// save writes all findings, sorted by endpoint, kind and path, an empty list when there are none.
+func (d *driftDetector) save() error {
+	if d.mode == driftOff {
+		// nothing was checked, a file of an earlier run would tell otherwise
+		if err := os.Remove(schemaDriftFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	d.mu.Lock()
+	findings := make([]*driftFinding, 0, len(d.findings)+len(d.absent))
+	for _, f := range d.findings {
+		findings = append(findings, f)
+	}
+	for _, f := range d.absent {
+		log.Warnw("upstream schema drift",
+			zap.String("endpoint", f.Endpoint),
+			zap.String("kind", f.Kind),
+			zap.String("path", f.Path),
+			zap.Strings("urls", f.SampleURLs))
+		findings = append(findings, f)
+	}
+	d.mu.Unlock()
+	// written even without findings, so a drifted earlier run is not reported again
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Endpoint != b.Endpoint {
+			return a.Endpoint < b.Endpoint
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Path < b.Path
+	})
+	content, err := json.MarshalIndent(findings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(schemaDriftFile, content, 0666)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestDriftSchoolNum(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	d := newDriftDetector(driftFail)
+	body := `{"code":"0000","message":"成功","data":{"31":{"school_name":"北京大学"},"32":{"school_name":"中国人民大学"},"140":{"school_name":"清华大学"}},"md5":"3b5d5c3712955042212316173ccf37be"}`
+	d.observe(stageSchoolNum, "schoolnum.json", []byte(body))
+	for _, f := range d.findings {
+		t.Errorf("unexpected finding %v|%v|%v|%v", f.Endpoint, f.Kind, f.Path, f.Got)
+	}
+	if d.failed() {
+		t.Error("a healthy schoolnum.json fails the run")
+	}
+
+	d.observe(stageSchoolNum, "schoolnum.json", []byte(`{"data":{"31":{"school_name":"北京大学","city":"北京"}}}`))
+	if _, ok := d.findings[stageSchoolNum+"|"+driftNew+"|data.{}.city|string"]; !ok {
+		t.Error("new field of a map value not reported")
+	}
+}
+
+func TestDriftSpecialScore(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	d := newDriftDetector(driftFail)
+	body := `{"code":"0000","message":"成功","data":{"1_7_0":{"numFound":1,"item":[{"spname":"数学","min":"600","min_section":"3000"}]},"2_8_0":[{"spname":"法学","min":"550"}]}}`
+	d.observe(stageSpecialScore, "schoolspecialscore", []byte(body))
+	for _, f := range d.findings {
+		t.Errorf("unexpected finding %v|%v|%v|%v", f.Endpoint, f.Kind, f.Path, f.Got)
+	}
+}
+
+func TestDriftSaveClean(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	inTempDir(t)
+	must(os.WriteFile(schemaDriftFile, []byte(`[{"Endpoint":"school_num"}]`), 0666))
+	if err := newDriftDetector(driftWarn).save(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(schemaDriftFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "[]" {
+		t.Errorf("clean run left %s", content)
+	}
+}
+
+func TestDriftMissing(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	inTempDir(t)
+	d := newDriftDetector(driftFail)
+	// a field left out by some items is optional, not missing
+	d.observe(stageSchoolNum, "a", []byte(`{"data":{"31":{"school_name":"北京大学"},"32":{}}}`))
+	d.observe(stageSchoolNum, "b", []byte(`{"data":{"140":{}}}`))
+	if d.count() != 0 {
+		t.Errorf("%v findings for a field some items hold", d.count())
+	}
+
+	d = newDriftDetector(driftFail)
+	d.observe(stageSchoolNum, "a", []byte(`{"data":{"31":{},"32":{}}}`))
+	d.observe(stageSchoolNum, "b", []byte(`{"data":{"140":{}}}`))
+	if d.failed() {
+		t.Error("missing field fails the run")
+	}
+	if err := d.save(); err != nil {
+		t.Fatal(err)
+	}
+	var findings []driftFinding
+	content, _ := os.ReadFile(schemaDriftFile)
+	if err := json.Unmarshal(content, &findings); err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Kind != driftMissing || findings[0].Path != "data.{}.school_name" || findings[0].Count != 3 {
+		t.Errorf("findings %+v, want data.{}.school_name missing from 3 items", findings)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// pagination styles
+const (
+	pageNone  = iota // one response per request
+	pageIndex        // page index appended to the url arguments, numFound items in pages of pageSize
+)
+
+const pageSize = 10
+
+var errEmptyResponse = errors.New("empty response")
+
+// endpoint declares a static-data.gaokao.cn resource once: its url, key dimensions,
+// pagination, response type and output layout. endpointWorker fetches, pages, parses
+// and stores any declared endpoint.
+type endpoint struct {
+	Stage       string      // budget, checkpoint, drift and report name
+	URLFormat   string      // fmt format taking the Dims values, then the page index when paged
+	Dims        []string    // request dimensions in url order, e.g. year, school, province
+	Paging      int         // pageNone or pageIndex
+	CheckStatus bool        // log non 200 responses
+	Response    interface{} // the response decodes into it, known fields for drift detection
+	Dir         string      // output directory
+	RawDir      string      // optional, raw responses of single request tasks are kept here
+
+	// Build turns the responses of a task into the value stored for it, nil stores nothing.
+	Build func(task endpointTask, responses []endpointResponse) (interface{}, error)
+	// Store, when set, replaces writing the value as json to Dir/<file>.
+	Store func(task endpointTask, v interface{}) error
+	// File names the output of a task, default <task key>.json.
+	File func(task endpointTask) string
+
+	total  atomic.Int64
+	failed atomic.Int64
+}
+
+// endpointTask is a unit of work and of checkpointing: all requests stored in one output file.
+type endpointTask struct {
+	Key      []string   // e.g. [school, prov]
+	Requests [][]string // values of Dims, e.g. [[year, school, prov, type, batch]...]
+}
+
+func (t endpointTask) key() string {
+	return strings.Join(t.Key, "_")
+}
+
+// endpointResponse is the data part of one request, Items of all pages when paged.
+type endpointResponse struct {
+	Args  []string
+	Data  json.RawMessage
+	Items []json.RawMessage
+	Raw   []byte // whole body, only when not paged
+}
+
+func (e *endpoint) url(args []string, page int) string {
+	if len(args) != len(e.Dims) {
+		panic(fmt.Sprintf("endpoint %v takes %v, got %v", e.Stage, e.Dims, args))
+	}
+	values := make([]interface{}, 0, len(args)+1)
+	for _, a := range args {
+		values = append(values, a)
+	}
+	if e.Paging == pageIndex {
+		values = append(values, page)
+	}
+	return fmt.Sprintf(e.URLFormat, values...)
+}
+
+func (e *endpoint) file(task endpointTask) string {
+	if e.File != nil {
+		return e.File(task)
+	}
+	return task.key() + ".json"
+}
+
+// get fetches the response of args, all pages of it when paged. only budget
+// exhaustion leaves the response unknown, other errors, a failed page too, mean it failed.
+func (e *endpoint) get(task endpointTask, args []string) (endpointResponse, error) {
+	resp := endpointResponse{Args: args}
+	url := e.url(args, 1)
+	content, err := request(url, e.CheckStatus)
+	if err != nil {
+		return resp, err
+	}
+	schemaDrift.observe(e.Stage, url, content)
+	if e.RawDir != "" {
+		if err := outputSink.write(e.RawDir, strings.TrimSuffix(e.file(task), ".json"), rawResponse(content)); err != nil {
+			log.Fatalw("write raw response failed", zap.Error(err), zap.String("stage", e.Stage))
+		}
+	}
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(content, &body); err != nil {
+		log.Errorw("unmarshal response failed", zap.Error(err), zap.String("url", url))
+		return resp, err
+	}
+	if e.Paging == pageNone {
+		resp.Data, resp.Raw = body.Data, content
+		return resp, nil
+	}
+	numFound, err := resp.addPage(body.Data)
+	if err != nil {
+		log.Errorw("unmarshal response page failed", zap.Error(err), zap.String("url", url))
+		return resp, err
+	}
+	for page := 2; page <= int(math.Ceil(float64(numFound)/pageSize)); page++ {
+		// a missing page fails the whole response, its task is fetched again on resume
+		url := e.url(args, page)
+		content, err := request(url, e.CheckStatus)
+		if err != nil {
+			return resp, err
+		}
+		schemaDrift.observe(e.Stage, url, content)
+		body.Data = nil
+		if err := json.Unmarshal(content, &body); err != nil {
+			log.Errorw("unmarshal response failed", zap.Error(err), zap.String("url", url))
+			return resp, err
+		}
+		if _, err := resp.addPage(body.Data); err != nil {
+			log.Errorw("unmarshal response page failed", zap.Error(err), zap.String("url", url))
+			return resp, err
+		}
+	}
+	if len(resp.Items) == 0 {
+		return resp, errEmptyResponse
+	}
+	return resp, nil
+}
+
+func (r *endpointResponse) addPage(data json.RawMessage) (int, error) {
+	var page struct {
+		NumFound int               `json:"numFound"`
+		Item     []json.RawMessage `json:"item"`
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return 0, err
+	}
+	r.Items = append(r.Items, page.Item...)
+	return page.NumFound, nil
+}
+
+func (e *endpoint) store(task endpointTask, v interface{}) error {
+	if e.Store != nil {
+		return e.Store(task, v)
+	}
+	return outputSink.write(e.Dir, strings.TrimSuffix(e.file(task), ".json"), v)
+}
+
+type endpointResult struct {
+	task  endpointTask
+	value interface{}
+}
+
+func endpointCollector(e *endpoint, dataCh chan endpointResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for result := range dataCh {
+		if err := e.store(result.task, result.value); err != nil {
+			log.Errorw("store response failed", zap.Error(err), zap.String("stage", e.Stage), zap.String("key", result.task.key()))
+		}
+	}
+}
+
+func endpointWorker(e *endpoint, taskCh chan endpointTask, collectorCh chan endpointResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for task := range taskCh {
+		responses := make([]endpointResponse, 0, len(task.Requests))
+		interrupted, failed := false, false
+		for _, args := range task.Requests {
+			resp, err := e.get(task, args)
+			if errors.Is(err, errBudgetExhausted) {
+				// leave the whole task to the next run
+				interrupted = true
+				break
+			}
+			e.total.Add(1)
+			if err != nil {
+				e.failed.Add(1)
+				// an empty response is an answer, anything else is fetched again on resume
+				failed = failed || !errors.Is(err, errEmptyResponse)
+				continue
+			}
+			responses = append(responses, resp)
+		}
+		if interrupted {
+			continue
+		}
+		if len(responses) != 0 {
+			v, err := e.Build(task, responses)
+			if err != nil {
+				log.Errorw("build response failed", zap.Error(err), zap.String("stage", e.Stage), zap.String("key", task.key()))
+				e.failed.Add(1)
+				failed = true
+			} else if v != nil {
+				collectorCh <- endpointResult{task: task, value: v}
+			}
+		}
+		// failed tasks stay pending, what succeeded is stored and replaced by the retry
+		if !failed {
+			progress.markDone(e.Stage, task.key())
+		}
+	}
+}
+
+// runEndpointStage fetches tasks of e in parallel, tasks done by an earlier run are skipped.
+func runEndpointStage(e *endpoint, tasks []endpointTask) {
+	keys := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		keys = append(keys, task.key())
+	}
+	report.track(e.Stage, keys)
+	crawlBudget.setStage(e.Stage)
+	if e.Dir != "" {
+		mkdir(e.Dir)
+	}
+	if e.RawDir != "" {
+		mkdir(e.RawDir)
+	}
+	taskCh := make(chan endpointTask, chanBuffer)
+	collectorCh := make(chan endpointResult, chanBuffer)
+	wg, collectorWG := &sync.WaitGroup{}, &sync.WaitGroup{}
+	collectorWG.Add(1)
+	go endpointCollector(e, collectorCh, collectorWG)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go endpointWorker(e, taskCh, collectorCh, wg)
+	}
+	for index, task := range tasks {
+		if interrupted() {
+			break
+		}
+		if progress.isDone(e.Stage, task.key()) {
+			continue
+		}
+		taskCh <- task
+		if index%100 == 0 {
+			log.Infof("%v/%v %v have been processed", index, len(tasks), e.Stage)
+		}
+	}
+	close(taskCh)
+	wg.Wait()
+	close(collectorCh)
+	collectorWG.Wait()
+}
+
+// ytbGroupTasks turns [school, prov] -> [[year, type, batch]...] into tasks of endpoints
+// with year, school, province, type and batch dimensions, sorted by key.
+func ytbGroupTasks(groups map[[2]string][][3]string) []endpointTask {
+	tasks := make([]endpointTask, 0, len(groups))
+	for k, v := range groups {
+		task := endpointTask{Key: []string{k[0], k[1]}}
+		for _, ytb := range v {
+			task.Requests = append(task.Requests, []string{ytb[0], k[0], k[1], ytb[1], ytb[2]})
+		}
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Key[0] != tasks[j].Key[0] {
+			return lessID(tasks[i].Key[0], tasks[j].Key[0])
+		}
+		return lessID(tasks[i].Key[1], tasks[j].Key[1])
+	})
+	return tasks
+}
+
+// ytbDims are the dimensions of the paged per year, type and batch endpoints.
+var ytbDims = []string{"year", "school", "province", "type", "batch"}
+
+func ytbOf(args []string) (year, typ, batch string) {
+	return args[0], args[3], args[4]
+}
+
+// decodeItems decodes the items of a paged response into a slice pointed to by dst.
+func decodeItems(items []json.RawMessage, dst interface{}) error {
+	return json.Unmarshal(joinItems(items), dst)
+}
+
+func joinItems(items []json.RawMessage) []byte {
+	buf := make([]byte, 0, 2)
+	buf = append(buf, '[')
+	for i, item := range items {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, item...)
+	}
+	return append(buf, ']')
+}
+
+// schoolFile names per school files <id>_<name>.json, like the raw files always were.
+func schoolFile(task endpointTask) string {
+	return fmt.Sprintf("%v_%v.json", task.Key[0], schoolIDNameMap[task.Key[0]])
+}
+
+// endpoints is the registry, in crawl order.
+var endpoints = []*endpoint{
+	schoolListEndpoint,
+	schoolNumEndpoint,
+	schoolInfoEndpoint,
+	schoolPTBEndpoint,
+	specialDetailEndpoint,
+	specialPlanEndpoint,
+	schoolScoreEndpoint,
+	specialScoreEndpoint,
+}
+
+var schoolListEndpoint = &endpoint{
+	Stage:       stageSchoolList,
+	URLFormat:   "https://static-data.gaokao.cn/www/2.0/school/name.json",
+	CheckStatus: true,
+	Response:    school{},
+}
+
+var schoolInfoEndpoint = &endpoint{
+	Stage:       stageSchoolInfo,
+	URLFormat:   "https://static-data.gaokao.cn/www/2.0/school/%v/info.json",
+	Dims:        []string{"school"},
+	CheckStatus: true,
+	Response:    info{},
+	Dir:         schoolInfoDir,
+	RawDir:      schoolInfoRawDir,
+	File:        schoolFile,
+	Build: func(task endpointTask, responses []endpointResponse) (interface{}, error) {
+		var data schoolInfo
+		if err := json.Unmarshal(responses[0].Data, &data); err != nil {
+			return nil, err
+		}
+		return data.Profile(), nil
+	},
+}
+
+// schoolPTBResult is the raw provincescore.json of a school and its ptb.txt lines.
+type schoolPTBResult struct {
+	raw   []byte
+	lines []string
+}
+
+// schoolPTBEndpoint feeds ptb.txt. the raw responses are always written per file: the
+// school score stage reads them back per school.
+var schoolPTBEndpoint = &endpoint{
+	Stage:     stageSchoolPTB,
+	URLFormat: "https://static-data.gaokao.cn/www/2.0/school/%v/dic/provincescore.json",
+	Dims:      []string{"school"},
+	Response:  ptb{},
+	Dir:       schoolPTBRawDir,
+	File:      schoolFile,
+	Build: func(task endpointTask, responses []endpointResponse) (interface{}, error) {
+		var schoolPTB ptb
+		if err := json.Unmarshal(responses[0].Raw, &schoolPTB); err != nil {
+			return nil, err
+		}
+		return schoolPTBResult{raw: responses[0].Raw, lines: ptbLines(mustToInt(task.Key[0]), schoolPTB)}, nil
+	},
+	// stores run in the single collector, so appending to ptb.txt needs no lock
+	Store: func(task endpointTask, v interface{}) error {
+		result := v.(schoolPTBResult)
+		if err := os.WriteFile(path.Join(schoolPTBRawDir, schoolFile(task)), result.raw, 0666); err != nil {
+			return err
+		}
+		if len(result.lines) == 0 {
+			return nil
+		}
+		f, err := os.OpenFile(schoolPTBFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteString(strings.Join(result.lines, "\n") + "\n"); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	},
+}
+
+var specialDetailEndpoint = &endpoint{
+	Stage:     stageSpecialDetail,
+	URLFormat: "https://static-data.gaokao.cn/www/2.0/schoolspecialindex/%v/%v/%v/%v/%v/%v.json",
+	Dims:      ytbDims,
+	Paging:    pageIndex,
+	Response:  SchoolSpecial{},
+	Dir:       specialDetailDir,
+	Build: func(task endpointTask, responses []endpointResponse) (interface{}, error) {
+		sp := SchoolProv{
+			SchoolID:     task.Key[0],
+			ProvinceID:   task.Key[1],
+			ProvinceName: provinceDict.name(task.Key[1]),
+			YTBSpecials:  make([]YTBSpecial, 0, len(responses)),
+		}
+		for _, resp := range responses {
+			var specials []Special
+			if err := decodeItems(resp.Items, &specials); err != nil {
+				return nil, err
+			}
+			year, typ, batch := ytbOf(resp.Args)
+			sp.YTBSpecials = append(sp.YTBSpecials, YTBSpecial{
+				Year:      year,
+				Typ:       typ,
+				TypName:   typeDict.name(typ),
+				Batch:     batch,
+				BatchName: batchDict.name(batch),
+				Special:   typeSpecials(specials),
+			})
+		}
+		return sp, nil
+	},
+	Store: func(task endpointTask, v interface{}) error {
+		sp := v.(SchoolProv)
+		if err := outputSink.write(specialDetailDir, task.key(), sp); err != nil {
+			return err
+		}
+		return writeSpecialGroups(outputSink, specialGroupDir, sp)
+	},
+}
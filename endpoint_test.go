@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testCrawl sets up the crawl globals against a test server in a temporary directory.
+func testCrawl(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	log = zap.NewNop().Sugar()
+	inTempDir(t)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	crawlBudget = newBudget(0, nil, 0, 0)
+	upstream = newCircuitBreaker(0.5, 100, time.Second, time.Minute)
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient = &http.Client{Transport: hostRewriter{target: target, next: srv.Client().Transport}}
+	schemaDrift = newDriftDetector(driftOff)
+	progress = loadCheckpoint()
+	report = newRunReport()
+	outputSink = fileSink{}
+	return srv
+}
+
+// hostRewriter sends every request to the test server, whatever host the endpoint names.
+type hostRewriter struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (h hostRewriter) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host, r.Host = h.target.Scheme, h.target.Host, h.target.Host
+	return h.next.RoundTrip(r)
+}
+
+// requestLog records the paths a test server was asked for.
+type requestLog struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (l *requestLog) add(p string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.paths = append(l.paths, p)
+}
+
+func (l *requestLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := l.paths
+	l.paths = nil
+	return res
+}
+
+func TestEndpointFailedTaskResumes(t *testing.T) {
+	failing := true
+	requests := &requestLog{}
+	srv := testCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r.URL.Path)
+		if failing && r.URL.Path == "/2.json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"id":%q}}`, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".json"))
+	})
+	e := &endpoint{
+		Stage:     "test",
+		URLFormat: srv.URL + "/%v.json",
+		Dims:      []string{"id"},
+		Response:  struct{}{},
+		Dir:       "out",
+		Build: func(task endpointTask, responses []endpointResponse) (interface{}, error) {
+			return responses[0].Data, nil
+		},
+	}
+	tasks := []endpointTask{
+		{Key: []string{"1"}, Requests: [][]string{{"1"}}},
+		{Key: []string{"2"}, Requests: [][]string{{"2"}}},
+	}
+	runEndpointStage(e, tasks)
+	if !progress.isDone("test", "1") {
+		t.Error("fetched task not done")
+	}
+	if progress.isDone("test", "2") {
+		t.Error("failed task marked done")
+	}
+	if e.failed.Load() != 1 {
+		t.Errorf("%v failures counted, want 1", e.failed.Load())
+	}
+	requests.take()
+
+	// the next run resumes from the checkpoint and only fetches the failed task
+	if err := progress.save(); err != nil {
+		t.Fatal(err)
+	}
+	progress = loadCheckpoint()
+	failing = false
+	runEndpointStage(e, tasks)
+	if got := requests.take(); len(got) != 1 || got[0] != "/2.json" {
+		t.Errorf("resumed run requested %v, want [/2.json]", got)
+	}
+	if !progress.isDone("test", "2") {
+		t.Error("retried task not done")
+	}
+	if _, err := os.Stat("out/2.json"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEndpointFailedPageFailsTask(t *testing.T) {
+	srv := testCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/2.json") {
+			http.NotFound(w, r)
+			return
+		}
+		items := make([]json.RawMessage, pageSize)
+		for i := range items {
+			items[i] = json.RawMessage(`{}`)
+		}
+		content, _ := json.Marshal(map[string]interface{}{"data": map[string]interface{}{"numFound": 15, "item": items}})
+		w.Write(content)
+	})
+	e := &endpoint{
+		Stage:     "paged",
+		URLFormat: srv.URL + "/%v/%v.json",
+		Dims:      []string{"id"},
+		Paging:    pageIndex,
+		Response:  struct{}{},
+		Dir:       "out",
+		Build: func(task endpointTask, responses []endpointResponse) (interface{}, error) {
+			return len(responses[0].Items), nil
+		},
+	}
+	runEndpointStage(e, []endpointTask{{Key: []string{"1"}, Requests: [][]string{{"1"}}}})
+	if progress.isDone("paged", "1") {
+		t.Error("task with a failed page marked done")
+	}
+	if e.failed.Load() != 1 {
+		t.Errorf("%v failures counted, want 1", e.failed.Load())
+	}
+	if _, err := os.Stat("out/1.json"); !os.IsNotExist(err) {
+		t.Errorf("incomplete response stored: %v", err)
+	}
+}
+
+func TestSchoolPTBEndpoint(t *testing.T) {
+	failing := true
+	bodies := map[string]string{
+		"/www/2.0/school/31/dic/provincescore.json": `{"code":"0000","data":{"data":[{"year":2023,"province":[{"pid":45,"type":[1,2],"batch":[7]}]}]}}`,
+		"/www/2.0/school/32/dic/provincescore.json": `{"code":"0000","data":{"data":[{"year":2023,"province":[{"pid":44,"type":[2073],"batch":[14]}]}]}}`,
+	}
+	testCrawl(t, func(w http.ResponseWriter, r *http.Request) {
+		if failing && strings.Contains(r.URL.Path, "/32/") {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, bodies[r.URL.Path])
+	})
+	schoolIDNameMap["31"], schoolIDNameMap["32"] = "北京大学", "中国人民大学"
+	tasks := []endpointTask{
+		{Key: []string{"31"}, Requests: [][]string{{"31"}}},
+		{Key: []string{"32"}, Requests: [][]string{{"32"}}},
+	}
+	runEndpointStage(schoolPTBEndpoint, tasks)
+	if progress.isDone(stageSchoolPTB, "32") {
+		t.Error("failed school marked done")
+	}
+	failing = false
+	runEndpointStage(schoolPTBEndpoint, tasks)
+
+	raw, err := os.ReadFile("school_ptb/31_北京大学.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != bodies["/www/2.0/school/31/dic/provincescore.json"] {
+		t.Errorf("raw response changed: %s", raw)
+	}
+	content, err := os.ReadFile(schoolPTBFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2023,31,45,1,7\n2023,31,45,2,7\n2023,32,44,2073,14\n"
+	if string(content) != want {
+		t.Errorf("ptb.txt:\n%s\nwant\n%v", content, want)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+	"go.uber.org/zap"
+)
+
+// export formats
+const (
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+)
+
+// exportHeader is the header of the counselor table, one row per school, major and year.
+var exportHeader = []string{
+	"年份", "学校ID", "学校", "985", "211", "双一流", "城市",
+	"专业", "层次", "门类", "专业类", "专业组",
+	"科类", "批次", "招生类型",
+	"最低分", "平均分", "最高分", "最低位次",
+}
+
+// exportRow is a flattened special of a school in a year.
+type exportRow struct {
+	AdmissionScore
+	Profile   SchoolProfile
+	TypeName  string
+	BatchName string
+	SgName    string
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "是"
+	}
+	return ""
+}
+
+func (r exportRow) record() []string {
+	// missing numbers stay empty cells instead of "-"
+	cell := func(s fmt.Stringer) string {
+		if v := s.String(); v != "-" {
+			return v
+		}
+		return ""
+	}
+	return []string{
+		strconv.Itoa(r.Year), r.SchoolID, firstNonEmpty(r.Profile.Name, r.SchoolName),
+		yesNo(r.Profile.Is985), yesNo(r.Profile.Is211), yesNo(r.Profile.IsDualClass), r.Profile.CityName,
+		r.SpecialName, r.Level1Name, r.Level2Name, r.Level3Name, r.SgName,
+		r.TypeName, firstNonEmpty(r.LocalBatchName, r.BatchName), r.ZslxName,
+		cell(r.Min), cell(r.Average), cell(r.Max), cell(r.MinSection),
+	}
+}
+
+// parseYearRange parses "2021", "2019-2023" or "" (every year).
+func parseYearRange(s string) (from, to int, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 9999, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	if from, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, fmt.Errorf("invalid year range %q", s)
+	}
+	to = from
+	if len(parts) == 2 {
+		if to, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil || to < from {
+			return 0, 0, fmt.Errorf("invalid year range %q", s)
+		}
+	}
+	return from, to, nil
+}
+
+// exportRows flattens the special_detail records of a province within [from, to].
+func exportRows(dir, province string, from, to int) ([]exportRow, error) {
+	profiles, err := loadSchoolProfiles(filepath.Join(dir, schoolInfoDir))
+	if err != nil {
+		return nil, err
+	}
+	loadSchoolNames(filepath.Join(dir, schoolListFile))
+	rows := make([]exportRow, 0)
+	onlyProvince := func(key string) bool { return provinceOfKey(key) == province }
+	err = walkSpecialDetail(filepath.Join(dir, specialDetailDir), onlyProvince, func(file string, sp SchoolProv) error {
+		if sp.ProvinceID != province {
+			return nil
+		}
+		for _, ytb := range sp.YTBSpecials {
+			year, _ := strconv.Atoi(ytb.Year)
+			if year < from || year > to {
+				continue
+			}
+			for _, s := range ytb.Special {
+				rows = append(rows, exportRow{
+					AdmissionScore: admissionScoreFromSpecial(s, sp.SchoolID, sp.ProvinceID, year, ytb.Typ, ytb.Batch),
+					Profile:        profiles[sp.SchoolID],
+					TypeName:       ytb.TypName.ZH,
+					BatchName:      ytb.BatchName.ZH,
+					SgName:         s.SgName,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Year != rows[j].Year {
+			return rows[i].Year > rows[j].Year
+		}
+		if rows[i].SchoolID != rows[j].SchoolID {
+			return lessID(rows[i].SchoolID, rows[j].SchoolID)
+		}
+		return rows[i].SpecialName < rows[j].SpecialName
+	})
+	return rows, nil
+}
+
+// writeExportCSV writes rows as UTF-8 CSV with a BOM, which Excel needs to detect the encoding.
+func writeExportCSV(file string, rows []exportRow) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString("\xEF\xBB\xBF"); err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write(exportHeader); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := w.Write(r.record()); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// numericColumns are written as numbers to xlsx, so Excel sorts and filters them as such.
+var numericColumns = map[int]bool{0: true, 15: true, 16: true, 17: true, 18: true}
+
+// writeExportXLSX writes rows into a workbook with one sheet per year, latest year first.
+func writeExportXLSX(file string, rows []exportRow) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	byYear := make(map[int][]exportRow)
+	years := make([]int, 0)
+	for _, r := range rows {
+		if _, ok := byYear[r.Year]; !ok {
+			years = append(years, r.Year)
+		}
+		byYear[r.Year] = append(byYear[r.Year], r)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(years)))
+	if len(years) == 0 {
+		// an empty workbook still gets a header
+		years = append(years, 0)
+	}
+	for i, year := range years {
+		sheet := strconv.Itoa(year)
+		if i == 0 {
+			if err := f.SetSheetName("Sheet1", sheet); err != nil {
+				return err
+			}
+		} else if _, err := f.NewSheet(sheet); err != nil {
+			return err
+		}
+		sw, err := f.NewStreamWriter(sheet)
+		if err != nil {
+			return err
+		}
+		if err := sw.SetRow("A1", toCells(exportHeader, nil)); err != nil {
+			return err
+		}
+		for n, r := range byYear[year] {
+			cell, _ := excelize.CoordinatesToCellName(1, n+2)
+			if err := sw.SetRow(cell, toCells(r.record(), numericColumns)); err != nil {
+				return err
+			}
+		}
+		if err := sw.Flush(); err != nil {
+			return err
+		}
+		if err := f.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+			return err
+		}
+	}
+	return f.SaveAs(file)
+}
+
+func toCells(record []string, numeric map[int]bool) []interface{} {
+	cells := make([]interface{}, 0, len(record))
+	for i, v := range record {
+		if numeric[i] && v != "" {
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				cells = append(cells, n)
+				continue
+			}
+		}
+		cells = append(cells, v)
+	}
+	return cells
+}
+
+// exportCommand writes the counselor table of a province as csv and xlsx.
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dir := fs.String("dir", ".", "crawl output directory")
+	province := fs.String("province", "", "province id, required")
+	years := fs.String("years", "", "year or year range, e.g. 2023 or 2019-2023, empty means every year")
+	formats := fs.String("format", formatCSV+","+formatXLSX, "comma separated output formats: csv, xlsx")
+	out := fs.String("out", "", "output file name without extension, default export_<province>_<years>")
+	must(fs.Parse(args))
+	if *province == "" {
+		return fmt.Errorf("usage: export -province <province id> [flags]")
+	}
+	from, to, err := parseYearRange(*years)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = "export_" + *province
+		if *years != "" {
+			*out += "_" + strings.TrimSpace(*years)
+		}
+	}
+	rows, err := exportRows(*dir, *province, from, to)
+	if err != nil {
+		return err
+	}
+	for _, format := range strings.Split(*formats, ",") {
+		file := *out + "." + strings.TrimSpace(format)
+		switch strings.TrimSpace(format) {
+		case formatCSV:
+			err = writeExportCSV(file, rows)
+		case formatXLSX:
+			err = writeExportXLSX(file, rows)
+		default:
+			return fmt.Errorf("unknown export format %q", format)
+		}
+		if err != nil {
+			return err
+		}
+		log.Infow("exported", zap.String("file", file), zap.Int("rows", len(rows)))
+	}
+	return nil
+}
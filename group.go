@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+const specialGroupDir = "special_group"
+
+// SchoolProvGroups holds the 专业组 of a school in a province, written to
+// special_group/<school>_<province>.json next to special_detail/.
+type SchoolProvGroups struct {
+	SchoolID     string
+	ProvinceID   string
+	ProvinceName CodeName
+	Groups       []SpecialGroup
+}
+
+// SpecialGroup is a 专业组 of one year, type and batch. students apply against the
+// group line, which is the lowest score and rank admitted into any member special.
+type SpecialGroup struct {
+	Year        string
+	Typ         string
+	TypName     CodeName
+	Batch       string
+	BatchName   CodeName
+	GroupID     string // special_group
+	Name        string // sg_name
+	Info        string // sg_info
+	Min         NullDecimal
+	MinSection  NullInt
+	Requirement SubjectRequirement
+	Members     []GroupMember
+}
+
+type GroupMember struct {
+	SpecialID   string
+	SpeID       string
+	Spname      string
+	ZslxName    string
+	Min         NullDecimal
+	MinSection  NullInt
+	Requirement *SubjectRequirement `json:",omitempty"`
+}
+
+// groupSpecials aggregates the specials of sp by year, type, batch and group. specials
+// without a group are left out, they are admitted by major.
+func groupSpecials(sp SchoolProv) SchoolProvGroups {
+	res := SchoolProvGroups{
+		SchoolID:     sp.SchoolID,
+		ProvinceID:   sp.ProvinceID,
+		ProvinceName: sp.ProvinceName,
+		Groups:       make([]SpecialGroup, 0),
+	}
+	for _, ytb := range sp.YTBSpecials {
+		groups := make(map[string]*SpecialGroup)
+		order := make([]string, 0)
+		for _, s := range ytb.Special {
+			if s.SpecialGroup == "" || s.SpecialGroup == "0" {
+				continue
+			}
+			g, ok := groups[s.SpecialGroup]
+			if !ok {
+				g = &SpecialGroup{
+					Year:        ytb.Year,
+					Typ:         ytb.Typ,
+					TypName:     ytb.TypName,
+					Batch:       ytb.Batch,
+					BatchName:   ytb.BatchName,
+					GroupID:     s.SpecialGroup,
+					Name:        s.SgName,
+					Info:        s.SgInfo,
+					Requirement: s.Special.GroupRequirement(),
+				}
+				groups[s.SpecialGroup] = g
+				order = append(order, s.SpecialGroup)
+			}
+			// the group line is the lowest member score and the largest member rank
+			if s.Min.Valid && (!g.Min.Valid || s.Min.Float < g.Min.Float) {
+				g.Min = s.Min
+			}
+			if s.MinSection.Valid && (!g.MinSection.Valid || s.MinSection.Int > g.MinSection.Int) {
+				g.MinSection = s.MinSection
+			}
+			g.Members = append(g.Members, GroupMember{
+				SpecialID:   s.SpecialID,
+				SpeID:       s.SpeID,
+				Spname:      s.Spname,
+				ZslxName:    s.ZslxName,
+				Min:         s.Min,
+				MinSection:  s.MinSection,
+				Requirement: s.Requirement,
+			})
+		}
+		sort.SliceStable(order, func(i, j int) bool { return lessID(order[i], order[j]) })
+		for _, id := range order {
+			res.Groups = append(res.Groups, *groups[id])
+		}
+	}
+	return res
+}
+
+// writeSpecialGroups writes the groups of sp into dir through s, schools without groups
+// get no record.
+func writeSpecialGroups(s sink, dir string, sp SchoolProv) error {
+	groups := groupSpecials(sp)
+	if len(groups.Groups) == 0 {
+		return nil
+	}
+	return s.write(dir, fmt.Sprintf("%v_%v", sp.SchoolID, sp.ProvinceID), groups)
+}
+
+// groupsCommand derives special_group/ from the special_detail/ of an existing crawl output.
+func groupsCommand(args []string) error {
+	fs := flag.NewFlagSet("groups", flag.ExitOnError)
+	dir := fs.String("dir", ".", "crawl output directory")
+	kind := fs.String("sink", sinkFile, "output sink: file writes a json per record, jsonl appends records to part files")
+	compress := fs.String("sink-compress", compressNone, "compression of jsonl parts: none, gzip or zstd")
+	rotateBytes := fs.Int64("sink-rotate-bytes", 256<<20, "size a jsonl part is rotated at, 0 never rotates")
+	must(fs.Parse(args))
+	s, err := newSink(*kind, *compress, *rotateBytes)
+	if err != nil {
+		return err
+	}
+	out := path.Join(*dir, specialGroupDir)
+	if err := os.MkdirAll(out, 0777); err != nil {
+		return err
+	}
+	files := 0
+	err = walkSpecialDetail(path.Join(*dir, specialDetailDir), nil, func(file string, sp SchoolProv) error {
+		files++
+		return writeSpecialGroups(s, out, sp)
+	})
+	if err != nil {
+		s.close()
+		return err
+	}
+	if err := s.close(); err != nil {
+		return err
+	}
+	log.Infow("special groups written", zap.Int("files", files), zap.String("dir", out))
+	return nil
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// layouts written by the scripts in test/
+const (
+	legacyProvinceScoreDir = "school_province_score"      // test/provincescore, provincescore.json per school as <id>_<name>.json
+	legacyCombFile         = "school_batch_type_comb.txt" // test/type, year,school,prov,type,batch per line
+	legacyDetailDir        = "school_detail"              // test/detail, first page of schoolspecialindex as <y,s,p,t,b>.json
+
+	legacyImportReportFile = "legacy_import_report.json"
+)
+
+// LegacyImportReport is written to legacy_import_report.json next to the imported output.
+type LegacyImportReport struct {
+	CreatedAt       time.Time
+	Layouts         []LegacyLayout
+	PTBLines        int
+	Records         int // year/type/batch records written to special_detail/
+	PartialRecords  int // records with only the first page of specials
+	AdmissionScores int
+}
+
+// LegacyLayout is one legacy output found in an input.
+type LegacyLayout struct {
+	Kind    string // legacyProvinceScoreDir, legacyCombFile or legacyDetailDir
+	Path    string
+	Files   int
+	Skipped []string // files or lines that did not parse
+}
+
+// detectLegacyLayouts finds the legacy outputs in p: p itself, or the layouts in the
+// directory the scripts ran in.
+func detectLegacyLayouts(p string) ([]LegacyLayout, error) {
+	stat, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	kinds := []string{legacyProvinceScoreDir, legacyCombFile, legacyDetailDir}
+	for _, kind := range kinds {
+		if filepath.Base(filepath.Clean(p)) == kind {
+			return []LegacyLayout{{Kind: kind, Path: p, Skipped: make([]string, 0)}}, nil
+		}
+	}
+	res := make([]LegacyLayout, 0)
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("%v is no legacy layout", p)
+	}
+	for _, kind := range kinds {
+		if _, err := os.Stat(path.Join(p, kind)); err == nil {
+			res = append(res, LegacyLayout{Kind: kind, Path: path.Join(p, kind), Skipped: make([]string, 0)})
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no legacy layout in %v, want %v", p, strings.Join(kinds, ", "))
+	}
+	return res, nil
+}
+
+type legacyImporter struct {
+	out    string
+	report *LegacyImportReport
+	// ptb.txt lines in the order found
+	ptb      map[string]bool
+	ptbLines []string
+	// [school, prov] -> records
+	details map[[2]string][]YTBSpecial
+	scores  []AdmissionScore
+}
+
+func (li *legacyImporter) addPTB(line string) {
+	if li.ptb[line] {
+		return
+	}
+	li.ptb[line] = true
+	li.ptbLines = append(li.ptbLines, line)
+}
+
+func jsonFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			res = append(res, e.Name())
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// importProvinceScore keeps the provincescore.json files as school_ptb/ raw responses
+// and derives their ptb.txt lines.
+func (li *legacyImporter) importProvinceScore(l *LegacyLayout) error {
+	names, err := jsonFiles(l.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Join(li.out, schoolPTBRawDir), 0777); err != nil {
+		return err
+	}
+	for _, name := range names {
+		file := path.Join(l.Path, name)
+		l.Files++
+		schoolID, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			l.Skipped = append(l.Skipped, file)
+			log.Warnw("legacy file skipped, name is not <id>_<name>.json", zap.String("file", file))
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var schoolPTB ptb
+		if err := json.Unmarshal(content, &schoolPTB); err != nil {
+			l.Skipped = append(l.Skipped, file)
+			log.Warnw("legacy file skipped", zap.Error(err), zap.String("file", file))
+			continue
+		}
+		for _, line := range ptbLines(schoolID, schoolPTB) {
+			li.addPTB(line)
+		}
+		if err := os.WriteFile(path.Join(li.out, schoolPTBRawDir, name), content, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importComb reads school_batch_type_comb.txt, which has the ptb.txt layout.
+func (li *legacyImporter) importComb(l *LegacyLayout) error {
+	f, err := os.Open(l.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	l.Files++
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		fields := strings.Split(scanner.Text(), ",")
+		values := make([]int, 0, 5)
+		for _, field := range fields {
+			v, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				break
+			}
+			values = append(values, v)
+		}
+		if len(fields) != 5 || len(values) != 5 {
+			l.Skipped = append(l.Skipped, fmt.Sprintf("%v:%v", l.Path, line))
+			continue
+		}
+		li.addPTB(ptbLine(values[0], values[1], values[2], values[3], values[4]))
+	}
+	return scanner.Err()
+}
+
+// importDetail reads the first page dumps of schoolspecialindex. a dump whose numFound
+// exceeds its items misses the later pages and is marked partial.
+func (li *legacyImporter) importDetail(l *LegacyLayout) error {
+	names, err := jsonFiles(l.Path)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		file := path.Join(l.Path, name)
+		l.Files++
+		// year, school, prov, type, batch
+		fields := strings.Split(strings.TrimSuffix(name, ".json"), ",")
+		if len(fields) != 5 {
+			l.Skipped = append(l.Skipped, file)
+			log.Warnw("legacy file skipped, name is not <y,s,p,t,b>.json", zap.String("file", file))
+			continue
+		}
+		year, school, prov, typ, batch := fields[0], fields[1], fields[2], fields[3], fields[4]
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var page SchoolSpecial
+		if err := json.Unmarshal(content, &page); err != nil {
+			l.Skipped = append(l.Skipped, file)
+			log.Warnw("legacy file skipped", zap.Error(err), zap.String("file", file))
+			continue
+		}
+		if len(page.Data.Item) == 0 {
+			continue
+		}
+		stat, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		partial := page.Data.NumFound > len(page.Data.Item)
+		ytb := YTBSpecial{
+			Year:       year,
+			Typ:        typ,
+			TypName:    typeDict.name(typ),
+			Batch:      batch,
+			BatchName:  batchDict.name(batch),
+			Special:    typeSpecials(page.Data.Item),
+			Provenance: &Provenance{Run: filepath.Dir(filepath.Clean(l.Path)), RunAt: stat.ModTime(), Source: file, Partial: partial},
+		}
+		key := [2]string{school, prov}
+		li.details[key] = append(li.details[key], ytb)
+		li.report.Records++
+		if partial {
+			li.report.PartialRecords++
+		}
+
+		y, _ := strconv.Atoi(year)
+		for _, s := range ytb.Special {
+			a := admissionScoreFromSpecial(s, school, prov, y, typ, batch)
+			a.Source, a.SourceFile = sourceLegacyDetail, file
+			if partial {
+				a.Warnings = append(a.Warnings, fmt.Sprintf("partial: first page only, %v of %v specials", len(page.Data.Item), page.Data.NumFound))
+			}
+			li.scores = append(li.scores, a)
+		}
+	}
+	return nil
+}
+
+// write writes ptb.txt and special_detail/ of everything imported.
+func (li *legacyImporter) write() error {
+	if len(li.ptbLines) != 0 {
+		content := strings.Join(li.ptbLines, "\n") + "\n"
+		if err := os.WriteFile(path.Join(li.out, schoolPTBFile), []byte(content), 0666); err != nil {
+			return err
+		}
+		li.report.PTBLines = len(li.ptbLines)
+	}
+	if len(li.details) != 0 {
+		if err := os.MkdirAll(path.Join(li.out, specialDetailDir), 0777); err != nil {
+			return err
+		}
+	}
+	for key, ytbs := range li.details {
+		sort.Slice(ytbs, func(i, j int) bool {
+			x, y := ytbs[i], ytbs[j]
+			if x.Year != y.Year {
+				return x.Year < y.Year
+			}
+			if x.Typ != y.Typ {
+				return lessID(x.Typ, y.Typ)
+			}
+			return lessID(x.Batch, y.Batch)
+		})
+		sp := SchoolProv{SchoolID: key[0], ProvinceID: key[1], ProvinceName: provinceDict.name(key[1]), YTBSpecials: ytbs}
+		if err := writeJSON(path.Join(li.out, specialDetailDir, key[0]+"_"+key[1]+".json"), sp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importLegacyCommand loads outputs of the scripts in test/ into a crawl output tree,
+// records are marked with their provenance and whether they are partial.
+func importLegacyCommand(args []string) error {
+	fs := flag.NewFlagSet("import-legacy", flag.ExitOnError)
+	out := fs.String("out", "legacy", "output directory, must not hold a special_detail/ or ptb.txt yet")
+	canonical := fs.Bool("canonical", true, "also add the admission scores to <out>/"+admissionScoreFile)
+	schoolList := fs.String("school-list", schoolListFile, "school list used to resolve school names")
+	must(fs.Parse(args))
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: import-legacy [flags] <dir with %v, %v or %v>...", legacyProvinceScoreDir, legacyCombFile, legacyDetailDir)
+	}
+	for _, existing := range []string{specialDetailDir, schoolPTBFile} {
+		if _, err := os.Stat(path.Join(*out, existing)); err == nil {
+			return fmt.Errorf("%v already has %v, import into an empty directory and merge it", *out, existing)
+		}
+	}
+	li := &legacyImporter{
+		out:     *out,
+		report:  &LegacyImportReport{CreatedAt: time.Now(), Layouts: make([]LegacyLayout, 0)},
+		ptb:     make(map[string]bool),
+		details: make(map[[2]string][]YTBSpecial),
+		scores:  make([]AdmissionScore, 0),
+	}
+	for _, p := range fs.Args() {
+		layouts, err := detectLegacyLayouts(p)
+		if err != nil {
+			return err
+		}
+		li.report.Layouts = append(li.report.Layouts, layouts...)
+	}
+	loadSchoolNames(*schoolList)
+	if err := os.MkdirAll(*out, 0777); err != nil {
+		return err
+	}
+	for i := range li.report.Layouts {
+		l := &li.report.Layouts[i]
+		var err error
+		switch l.Kind {
+		case legacyProvinceScoreDir:
+			err = li.importProvinceScore(l)
+		case legacyCombFile:
+			err = li.importComb(l)
+		case legacyDetailDir:
+			err = li.importDetail(l)
+		}
+		if err != nil {
+			return err
+		}
+		log.Infow("legacy layout imported", zap.String("kind", l.Kind), zap.String("path", l.Path),
+			zap.Int("files", l.Files), zap.Int("skipped", len(l.Skipped)))
+	}
+	if err := li.write(); err != nil {
+		return err
+	}
+	if *canonical && len(li.scores) != 0 {
+		if err := writeAdmissionScores(path.Join(*out, admissionScoreFile), li.scores); err != nil {
+			return err
+		}
+		li.report.AdmissionScores = len(li.scores)
+	}
+	log.Infow("legacy imported",
+		zap.String("out", *out),
+		zap.Int("ptb lines", li.report.PTBLines),
+		zap.Int("records", li.report.Records),
+		zap.Int("partial records", li.report.PartialRecords),
+		zap.Int("admission scores", li.report.AdmissionScores))
+	return writeJSON(path.Join(*out, legacyImportReportFile), li.report)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestDetectLegacyLayouts(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, legacyProvinceScoreDir), 0777)
+	os.Mkdir(filepath.Join(dir, legacyDetailDir), 0777)
+	os.WriteFile(filepath.Join(dir, "other.txt"), nil, 0666)
+
+	kinds := func(layouts []LegacyLayout) []string {
+		res := make([]string, 0)
+		for _, l := range layouts {
+			res = append(res, l.Kind)
+		}
+		return res
+	}
+	// the directory the scripts ran in
+	layouts, err := detectLegacyLayouts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kinds(layouts); !reflect.DeepEqual(got, []string{legacyProvinceScoreDir, legacyDetailDir}) {
+		t.Errorf("layouts %v", got)
+	}
+	// a layout itself, even a trailing slash
+	layouts, err = detectLegacyLayouts(filepath.Join(dir, legacyDetailDir) + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kinds(layouts); !reflect.DeepEqual(got, []string{legacyDetailDir}) {
+		t.Errorf("layouts %v", got)
+	}
+	for _, p := range []string{filepath.Join(dir, legacyDetailDir, "missing"), filepath.Join(dir, "other.txt"), t.TempDir()} {
+		if _, err := detectLegacyLayouts(p); err == nil {
+			t.Errorf("%v detected as a layout", p)
+		}
+	}
+}
+
+func TestImportLegacyDetailPartial(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	in := filepath.Join(t.TempDir(), legacyDetailDir)
+	os.Mkdir(in, 0777)
+	page := func(numFound int, names ...string) []byte {
+		var p SchoolSpecial
+		p.Data.NumFound = numFound
+		for _, name := range names {
+			p.Data.Item = append(p.Data.Item, Special{Spname: name})
+		}
+		content, _ := json.Marshal(p)
+		return content
+	}
+	// only the first page of 15 was kept, the other dump is complete
+	os.WriteFile(filepath.Join(in, "2023,31,45,1,7.json"), page(15, "数学", "物理"), 0666)
+	os.WriteFile(filepath.Join(in, "2022,31,45,1,7.json"), page(2, "数学", "物理"), 0666)
+	os.WriteFile(filepath.Join(in, "notes.json"), page(1, "数学"), 0666)
+
+	// the output directory is created with its parents
+	out := filepath.Join(t.TempDir(), "a", "b")
+	if err := importLegacyCommand([]string{"-out", out, "-school-list", filepath.Join(in, "missing.json"), in}); err != nil {
+		t.Fatal(err)
+	}
+	var report LegacyImportReport
+	content, err := os.ReadFile(filepath.Join(out, legacyImportReportFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Records != 2 || report.PartialRecords != 1 || report.AdmissionScores != 4 {
+		t.Errorf("records %v partial %v scores %v, want 2, 1 and 4", report.Records, report.PartialRecords, report.AdmissionScores)
+	}
+	if len(report.Layouts) != 1 || len(report.Layouts[0].Skipped) != 1 {
+		t.Errorf("layouts %+v, want notes.json skipped", report.Layouts)
+	}
+
+	var sp SchoolProv
+	content, err = os.ReadFile(filepath.Join(out, specialDetailDir, "31_45.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &sp); err != nil {
+		t.Fatal(err)
+	}
+	if len(sp.YTBSpecials) != 2 || sp.YTBSpecials[0].Provenance.Partial || !sp.YTBSpecials[1].Provenance.Partial {
+		t.Errorf("2022 complete and 2023 partial, got %+v", sp.YTBSpecials)
+	}
+	scores, err := readAdmissionScores(filepath.Join(out, admissionScoreFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range scores {
+		warned := len(a.Warnings) == 1 && strings.HasPrefix(a.Warnings[0], "partial")
+		if warned != (a.Year == 2023) {
+			t.Errorf("%v %v warnings %v", a.Year, a.SpecialName, a.Warnings)
+		}
+	}
+}
@@ -2,18 +2,15 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"math"
 	"net/http"
 	"os"
-	"path"
 	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"time"
 
 	"bitbucket.org/ai69/popua"
 	"github.com/bzssm/goclub/logger"
@@ -31,122 +28,153 @@ var (
 	schools         []schoolData
 	schoolIDNameMap = make(map[string]string, 0)
 
-	schoolInfoFailed    = &atomic.Int64{}
-	schoolPTBFailed     = &atomic.Int64{}
-	specialDetailFailed = &atomic.Int64{}
-	specialDetailTotal  = &atomic.Int64{}
+	maxRequests      = flag.Int64("max-requests", 0, "max upstream requests of the run, 0 means unlimited")
+	maxStageRequests = flag.String("max-stage-requests", "", "max upstream requests per stage, e.g. school_info=3000,special_detail=50000")
+	maxDuration      = flag.Duration("max-duration", 0, "max wall-clock duration of the run, 0 means unlimited")
+	maxBytes         = flag.Int64("max-bytes", 0, "max downloaded bytes of the run, 0 means unlimited")
+
+	requestTimeout   = flag.Duration("request-timeout", 30*time.Second, "timeout of a single upstream request")
+	breakerThreshold = flag.Float64("breaker-threshold", 0.5, "upstream error rate that opens the circuit breaker")
+	breakerWindow    = flag.Int("breaker-window", 100, "number of recent requests the error rate is computed over")
+	breakerCooldown  = flag.Duration("breaker-cooldown", 30*time.Second, "pause before a half-open probe is sent")
+	breakerMaxWait   = flag.Duration("breaker-max-wait", 30*time.Minute, "longest a request waits for an upstream outage to end, 0 waits until it does")
+	driftMode        = flag.String("drift", driftWarn, "upstream schema drift handling: off, warn or fail, which stops on new or retyped fields")
+	scoreYears       = flag.String("score-years", "2018,2019,2020,2021,2022,2023", "years of the special score stage")
+	scoreProvinces   = flag.String("score-provinces", "45", "province ids of the special score stage, empty skips the stage")
+	sinkKind         = flag.String("sink", sinkFile, "output sink: file writes a json per record, jsonl appends records to part files")
+	sinkCompress     = flag.String("sink-compress", compressNone, "compression of jsonl parts: none, gzip or zstd")
+	sinkRotateBytes  = flag.Int64("sink-rotate-bytes", 256<<20, "size a jsonl part is rotated at, 0 never rotates")
+
+	scoreYearList     []string
+	scoreProvinceList []string
+
+	crawlBudget *budget
+	upstream    *circuitBreaker
+	httpClient  *http.Client
+	schemaDrift *driftDetector
+	progress    *checkpoint
+	report      *runReport
 )
 
 // ptb stands for provice id, type id, batch id
 const (
-	schoolListURL  = "https://static-data.gaokao.cn/www/2.0/school/name.json"
 	schoolListFile = "school_list.json"
 
-	schoolInfoURLFormat = "https://static-data.gaokao.cn/www/2.0/school/%v/info.json"
-	schoolInfoDir       = "school_info"
-	schoolInfoRawDir    = "RAW_school_info"
+	schoolInfoDir    = "school_info"
+	schoolInfoRawDir = "RAW_school_info"
+
+	schoolPTBRawDir = "school_ptb"
+	schoolPTBFile   = "ptb.txt"
 
-	schoolPTBURLFormat = "https://static-data.gaokao.cn/www/2.0/school/%v/dic/provincescore.json"
-	schoolPTBRawDir    = "school_ptb"
-	schoolPTBFile      = "ptb.txt"
+	specialDetailDir = "special_detail"
 
-	// year, school id, province id, type, batch, index
-	specialDetailURLFormat = "https://static-data.gaokao.cn/www/2.0/schoolspecialindex/%v/%v/%v/%v/%v/%v.json"
-	specialDetailDir       = "special_detail"
+	// retries of an isolated upstream failure, failures during an outage are retried until the breaker
+	// closes or -breaker-max-wait passes
+	upstreamRetries = 3
+)
+
+// stage names, used by budgets, checkpoint and run report
+const (
+	stageSchoolList    = "school_list"
+	stageSchoolNum     = "school_num"
+	stageSchoolInfo    = "school_info"
+	stageSchoolPTB     = "school_ptb"
+	stageSpecialDetail = "special_detail"
+	stageSpecialPlan   = "special_plan"
+	stageSchoolScore   = "school_score"
+	stageSpecialScore  = "special_score"
 )
 
 func main() {
 	lgr, _, _ := logger.InitLogger(zapcore.InfoLevel, true, "")
 	log = lgr
+	if runCommand() {
+		return
+	}
+	crawl()
+	if schemaDrift.failed() {
+		os.Exit(1)
+	}
+}
+
+func crawl() {
+	flag.Parse()
+	stageLimits, err := parseStageLimits(*maxStageRequests)
+	if err != nil {
+		log.Fatalw("parse stage limits failed", zap.Error(err))
+	}
+	if scoreYearList, err = parseIntList(*scoreYears); err != nil {
+		log.Fatalw("parse score years failed", zap.Error(err))
+	}
+	if scoreProvinceList, err = parseIntList(*scoreProvinces); err != nil {
+		log.Fatalw("parse score provinces failed", zap.Error(err))
+	}
+	if outputSink, err = newSink(*sinkKind, *sinkCompress, *sinkRotateBytes); err != nil {
+		log.Fatalw("create output sink failed", zap.Error(err))
+	}
+	crawlBudget = newBudget(*maxRequests, stageLimits, *maxBytes, *maxDuration)
+	upstream = newCircuitBreaker(*breakerThreshold, *breakerWindow, *breakerCooldown, *breakerMaxWait)
+	httpClient = &http.Client{Timeout: *requestTimeout}
+	schemaDrift = newDriftDetector(*driftMode)
+	progress = loadCheckpoint()
+	report = newRunReport()
 	// stat
 	defer func() {
-		log.Infof("school info failed    : %v", schoolInfoFailed.Load())
-		log.Infof("school ptb failed     : %v", schoolPTBFailed.Load())
-		log.Infof("special detail total  : %v", specialDetailTotal.Load())
-		log.Infof("special detail failed : %v", specialDetailFailed.Load())
+		for _, e := range endpoints {
+			log.Infof("%-15v total: %v, failed: %v", e.Stage, e.total.Load(), e.failed.Load())
+		}
 	}()
-	// 1. get school list from: https://static-data.gaokao.cn/www/2.0/school/name.json
-	content, err := request(schoolListURL, true)
+	// checkpoint and report, runs before stat
+	defer finishRun()
+	// 1. school registry: name.json and schoolnum.json merged by id
+	registry, err := schoolRegistryStage()
+	if errors.Is(err, errBudgetExhausted) {
+		return
+	}
 	if err != nil {
+		log.Errorw("build school registry failed", zap.Error(err))
 		os.Exit(1)
 	}
-	// 1.1 write raw content to file
-	if err := os.WriteFile("RAW_"+schoolListFile, content, 0666); err != nil {
-		log.Fatalw("write school list raw failed", zap.Error(err))
-	}
-	// 1.2 load school info
-	var schoolJSON school
-	if err := json.Unmarshal(content, &schoolJSON); err != nil {
-		log.Fatalw("unmarshal school list failed", zap.Error(err))
-	}
-	schools = schoolJSON.Data
-	log.Info(schools[0].Name)
-	// 1.3 save to file
-	if content, err = json.MarshalIndent(schoolJSON, "", "  "); err != nil {
-		log.Fatalw("marshal school list failed", zap.Error(err))
-	}
-	if err := os.WriteFile(schoolListFile, content, 0666); err != nil {
+	schools = registry.schoolData()
+	// 1.1 save to file, school_list.json keeps the layout of name.json
+	if err := writeJSON(schoolListFile, school{Data: schools}); err != nil {
 		log.Fatalw("write school list failed", zap.Error(err))
 	}
-	// 1.4 load school id -> name map
+	// 1.2 load school id -> name map
 	for _, sc := range schools {
 		schoolIDNameMap[sc.SchoolID] = sc.Name
 	}
 	log.Infow("school list loaded", zap.Int("school num", len(schools))) // should be 2827
-
-	// 2. parallel get school info
-	// 2.0 init
-	mkdir(schoolInfoDir)
-	mkdir(schoolInfoRawDir)
-	schoolInfoIDCh := make(chan string, chanBuffer)
-	wg := &sync.WaitGroup{}
-	// 2.1 start worker
-	for i := 0; i < parallel; i++ {
-		wg.Add(1)
-		go schoolInfoWorker(schoolInfoIDCh, wg)
-	}
-	// 2.2 producer, send data
+	schoolIDs := make([]string, 0, len(schools))
 	for index, school := range schools {
 		if debug && index > debugCount {
 			break
 		}
-		schoolInfoIDCh <- school.SchoolID
-		if index%100 == 0 {
-			log.Infof("%v/%v school info have been processed", index, len(schools))
-		}
+		schoolIDs = append(schoolIDs, school.SchoolID)
+	}
+
+	// 2. parallel get school info
+	schoolTasks := make([]endpointTask, 0, len(schoolIDs))
+	for _, id := range schoolIDs {
+		schoolTasks = append(schoolTasks, endpointTask{Key: []string{id}, Requests: [][]string{{id}}})
+	}
+	runEndpointStage(schoolInfoEndpoint, schoolTasks)
+	if interrupted() {
+		return
 	}
-	close(schoolInfoIDCh)
-	wg.Wait()
 
 	// 3. parallel read province score: get year/type/batch group
-	// 3.0 init
-	mkdir(schoolPTBRawDir)
-	schoolPTBIDCh := make(chan string, chanBuffer)
-	schoolPTBCollectorCh := make(chan string, chanBuffer)
-	collectorWG := &sync.WaitGroup{}
-	// 3.1 start collector
-	collectorWG.Add(1)
-	go schoolPTBCollector(schoolPTBCollectorCh, collectorWG)
-	// 3.2 start worker
-	for i := 0; i < parallel; i++ {
-		wg.Add(1)
-		go schoolPTBWorker(schoolPTBIDCh, schoolPTBCollectorCh, wg)
-	}
-	// 3.3 producer
-	for index, school := range schools {
-		if debug && index > debugCount {
-			break
-		}
-		schoolPTBIDCh <- school.SchoolID
-		if index%100 == 0 {
-			log.Infof("%v/%v school ptb have been processed", index, len(schools))
+	if !progress.started(stageSchoolPTB) {
+		// a resumed run keeps the lines of finished schools
+		if err := os.Remove(schoolPTBFile); err != nil && !os.IsNotExist(err) {
+			log.Fatalw("remove ptb list failed", zap.Error(err))
 		}
 	}
-	close(schoolPTBIDCh)
-	wg.Wait()
-	close(schoolPTBCollectorCh)
-	collectorWG.Wait()
+	runEndpointStage(schoolPTBEndpoint, schoolTasks)
 	// ptb info may fail: 71
+	if interrupted() {
+		return
+	}
 
 	// 4. detail
 	// have to read from file. should be 433381 lines
@@ -161,7 +189,13 @@ func main() {
 	}
 	defer ptbFile.Close()
 	scanner := bufio.NewScanner(ptbFile)
+	// a resumed stage 3 appends to ptb.txt, lines of an interrupted school may repeat
+	seenPTB := make(map[string]bool)
 	for scanner.Scan() {
+		if seenPTB[scanner.Text()] {
+			continue
+		}
+		seenPTB[scanner.Text()] = true
 		// year, school, prov, type, batch
 		fields := strings.Split(scanner.Text(), ",")
 		key := [2]string{fields[1], fields[2]}
@@ -172,197 +206,142 @@ func main() {
 			detailDistributionMap[key] = v
 		}
 	}
-	// 4.1 init
-	reqCh := make(chan detailGroup, chanBuffer)
-	collectorCh := make(chan SchoolProv, chanBuffer)
-	mkdir(specialDetailDir)
-	// 4.2 start collector
-	collectorWG.Add(1)
-	go specialDetailCollector(collectorCh, collectorWG)
-	// 4.3 start worker
-	for i := 0; i < parallel; i++ {
-		wg.Add(1)
-		go specialDetailWorker(reqCh, collectorCh, wg)
-	}
-	// 4.4 producer
-	index := 0
-	for k, v := range detailDistributionMap {
-		reqCh <- detailGroup{
-			Key:   k,
-			Value: v,
-		}
-		if index%100 == 0 {
-			log.Infof("%v/%v special group have been processed", index, len(detailDistributionMap))
-		}
-		index++
+	groupTasks := ytbGroupTasks(detailDistributionMap)
+	mkdir(specialGroupDir)
+	runEndpointStage(specialDetailEndpoint, groupTasks)
+	if interrupted() {
+		return
 	}
-	close(reqCh)
-	wg.Wait()
-	close(collectorCh)
-	collectorWG.Wait()
 
-	// 5. zip
-}
+	// 5. enrollment plan, same [school, prov] groups as detail
+	runEndpointStage(specialPlanEndpoint, groupTasks)
+	if interrupted() {
+		return
+	}
 
-func specialDetailCollector(dataCh chan SchoolProv, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for schoolProv := range dataCh {
-		content, err := json.MarshalIndent(schoolProv, "", "  ")
-		if err != nil {
-			log.Errorw("marshal special detail failed",
-				zap.Error(err),
-				zap.String("school", schoolProv.SchoolID),
-				zap.String("prov", schoolProv.ProvinceID))
-			continue
-		}
-		file, err := os.OpenFile(path.Join(specialDetailDir, fmt.Sprintf("%v_%v.json", schoolProv.SchoolID, schoolProv.ProvinceID)), os.O_CREATE|os.O_RDWR, 0666)
-		if _, err := file.Write(content); err != nil {
-			log.Errorw("write special detail file failed", zap.String("file", file.Name()))
-			continue
-		}
+	// 6. school score lines, same [school, prov] groups as detail
+	runEndpointStage(schoolScoreEndpoint, groupTasks)
+	if interrupted() {
+		return
 	}
-}
 
-// group: [[school, prov] -> [y,t,b], [y,t,b]]
-func specialDetailWorker(groupCh chan detailGroup, collectorCh chan SchoolProv, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for group := range groupCh {
-		ytbSpecials := make([]YTBSpecial, 0)
-		// year, type, batch
-		for _, oneYTBData := range group.Value {
-			specialDetailTotal.Add(1)
-			specials := getSpecialDetailByPage(oneYTBData[0], group.Key[0], group.Key[1], oneYTBData[1], oneYTBData[2])
-			if specials == nil || len(specials) == 0 {
-				specialDetailFailed.Add(1)
-				continue
-			}
-			ytbSpecials = append(ytbSpecials, YTBSpecial{
-				Year:    oneYTBData[0],
-				Typ:     oneYTBData[1],
-				Batch:   oneYTBData[2],
-				Special: specials,
-			})
-		}
-		if len(ytbSpecials) != 0 {
-			collectorCh <- SchoolProv{
-				SchoolID:    group.Key[0],
-				ProvinceID:  group.Key[1],
-				YTBSpecials: ytbSpecials,
-			}
-		}
+	// 7. special score of configured years and provinces, replaces python_impl_2024
+	runEndpointStage(specialScoreEndpoint, specialScoreTasks(schoolIDs, scoreYearList, scoreProvinceList))
+	if interrupted() {
+		return
 	}
-}
 
-func getSpecialDetailByPage(year, school, prov, typ, batch string) []Special {
-	firstPageURL := fmt.Sprintf(specialDetailURLFormat, year, school, prov, typ, batch, 1)
-	content, err := request(firstPageURL, false)
-	if err != nil {
-		return nil
-	}
-	var ss SchoolSpecial
-	if err := json.Unmarshal(content, &ss); err != nil {
-		log.Error("unmarshal school special failed.", err, year, school, prov, typ, batch)
-		return nil
-	}
-	res := make([]Special, 0, ss.Data.NumFound)
-	// add page 1 data
-	res = append(res, ss.Data.Item...)
-	for page := 2; page <= int(math.Ceil(float64(ss.Data.NumFound)/10)); page++ {
-		url := fmt.Sprintf(specialDetailURLFormat, year, school, prov, typ, batch, page)
-		content, err = request(url, false)
-		if err != nil {
-			continue
-		}
-		if err := json.Unmarshal(content, &ss); err != nil {
-			log.Error("unmarshal school special failed.", err, year, school, prov, typ, batch, page)
-			continue
-		}
-		res = append(res, ss.Data.Item...)
+	// 8. major catalog, reads special_detail back
+	if err := outputSink.close(); err != nil {
+		log.Fatalw("close output sink failed", zap.Error(err))
 	}
-	return res
+	if err := majorCatalogStage(specialDetailDir, majorCatalogFile); err != nil {
+		log.Errorw("build major catalog failed", zap.Error(err))
+	}
+
+	// 9. zip
 }
 
-func schoolPTBCollector(collectorCh chan string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	f, err := os.OpenFile(schoolPTBFile, os.O_CREATE|os.O_RDWR|os.O_RDWR, 0666)
-	if err != nil {
-		panic(err)
-	}
-	defer func() {
-		must(f.Close())
-	}()
-	writer := bufio.NewWriter(f)
-	defer func() {
-		writer.Flush()
-	}()
-	for record := range collectorCh {
-		if len(record) <= 10 {
-			panic(record)
-		}
-		_, err := writer.WriteString(record + "\n")
-		if err != nil {
-			panic(err)
+// finishRun checkpoints an interrupted run or clears the checkpoint of a complete one, then writes the run report.
+func finishRun() {
+	if err := outputSink.close(); err != nil {
+		log.Errorw("close output sink failed", zap.Error(err))
+	}
+	for _, e := range endpoints {
+		report.Failed[e.Stage] = e.failed.Load()
+	}
+	upstream.mu.Lock()
+	report.BreakerTrips = upstream.trips
+	report.Requeued = upstream.requeued
+	upstream.mu.Unlock()
+	report.SchemaDrift = schemaDrift.count()
+	if err := schemaDrift.save(); err != nil {
+		log.Errorw("save schema drift failed", zap.Error(err))
+	}
+	report.Complete = !interrupted()
+	if interrupted() {
+		if err := progress.save(); err != nil {
+			log.Errorw("save checkpoint failed", zap.Error(err))
 		}
+		log.Infow("run stopped early, checkpoint saved", zap.String("file", checkpointFile))
+	} else if err := progress.remove(); err != nil {
+		log.Errorw("remove checkpoint failed", zap.Error(err))
+	}
+	if err := report.finish(crawlBudget, progress); err != nil {
+		log.Errorw("write run report failed", zap.Error(err))
 	}
 }
 
-func schoolPTBWorker(idCh, collectorCh chan string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for id := range idCh {
-		content, err := request(fmt.Sprintf(schoolPTBURLFormat, id), false)
-		if err != nil {
-			schoolPTBFailed.Add(1)
-			continue
-		}
-		// write raw content
-		if err := os.WriteFile(path.Join(schoolPTBRawDir, fmt.Sprintf("%v_%v.json", id, schoolIDNameMap[id])), content, 0666); err != nil {
-			log.Fatalw("write school ptb raw failed", zap.Error(err))
-		}
-		// load school info
-		var schoolPTB ptb
-		if err := json.Unmarshal(content, &schoolPTB); err != nil {
-			log.Fatalw("unmarshal school ptb failed", zap.Error(err), zap.String("id", id))
-		}
-		// generate year, school, province,
-		for _, yearData := range schoolPTB.Data.Data {
-			for _, provinceData := range yearData.Province {
-				for _, tb := range combination(provinceData.Type, provinceData.Batch) {
-					collectorCh <- fmt.Sprintf("%v,%v,%v,%v,%v", yearData.Year, mustToInt(id), provinceData.Pid, tb[0], tb[1])
-				}
+// interrupted tells whether the crawl has to stop before all work is done.
+func interrupted() bool {
+	return crawlBudget.exhausted() || schemaDrift.failed()
+}
+
+// ptbLines lists the ptb.txt lines of a school, one per year, province, type and batch.
+func ptbLines(schoolID int, schoolPTB ptb) []string {
+	res := make([]string, 0)
+	for _, yearData := range schoolPTB.Data.Data {
+		for _, provinceData := range yearData.Province {
+			for _, tb := range combination(provinceData.Type, provinceData.Batch) {
+				res = append(res, ptbLine(yearData.Year, schoolID, provinceData.Pid, tb[0], tb[1]))
 			}
 		}
 	}
+	return res
+}
+
+// ptbLine is year, school, prov, type, batch. names are resolved through the dictionaries
+// by whoever reads the codes.
+func ptbLine(year, schoolID, prov, typ, batch int) string {
+	return fmt.Sprintf("%v,%v,%v,%v,%v", year, schoolID, prov, typ, batch)
 }
 
-func schoolInfoWorker(idCh chan string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for id := range idCh {
-		content, err := request(fmt.Sprintf(schoolInfoURLFormat, id), true)
+// request fetches url through the crawl budget and the upstream circuit breaker.
+// a request failing during an upstream outage waits for the breaker to close, or to
+// probe with it, and is sent again without spending budget, so it is not reported as a
+// miss unless the outage outlasts the breaker's max wait.
+func request(url string, checkStatus bool) ([]byte, error) {
+	if err := crawlBudget.acquire(); err != nil {
+		return nil, err
+	}
+	var requeued bool
+	var outageWait time.Duration
+	for attempt := 1; ; {
+		var deadline time.Time
+		if requeued && upstream.maxWait > 0 {
+			deadline = time.Now().Add(upstream.maxWait - outageWait)
+		}
+		start := time.Now()
+		generation, probe, err := upstream.wait(deadline)
+		if requeued {
+			outageWait += time.Since(start)
+		}
 		if err != nil {
-			schoolInfoFailed.Add(1)
-			continue
+			log.Errorw("http request failed", zap.Error(err), zap.Duration("waited", outageWait), zap.String("url", url))
+			return nil, err
 		}
-		// write raw content
-		if err := os.WriteFile(path.Join(schoolInfoRawDir, fmt.Sprintf("%v_%v.json", id, schoolIDNameMap[id])), content, 0666); err != nil {
-			log.Fatalw("write school list raw failed", zap.Error(err))
+		content, err := fetch(url, checkStatus)
+		upstream.record(errors.Is(err, errUpstreamUnavailable), probe)
+		if !errors.Is(err, errUpstreamUnavailable) {
+			return content, err
 		}
-		// load school info
-		var schoolInfoJSON info
-		if err := json.Unmarshal(content, &schoolInfoJSON); err != nil {
-			log.Fatalw("unmarshal school info failed", zap.Error(err), zap.String("id", id))
+		if upstream.outage(generation) {
+			requeued = true
+			continue
 		}
-		// write to file
-		if content, err = json.MarshalIndent(schoolInfoJSON, "", "  "); err != nil {
-			log.Fatalw("marshal school info failed", zap.Error(err), zap.String("id", id))
+		if attempt >= upstreamRetries {
+			log.Errorw("http request failed", zap.Error(err), zap.String("url", url))
+			return nil, err
 		}
-		if err := os.WriteFile(path.Join(schoolInfoDir, fmt.Sprintf("%v_%v.json", id, schoolIDNameMap[id])), content, 0666); err != nil {
-			log.Fatalw("write school info failed", zap.Error(err), zap.String("id", id))
+		attempt++
+		if err := crawlBudget.acquire(); err != nil {
+			return nil, err
 		}
 	}
 }
 
-func request(url string, checkStatus bool) ([]byte, error) {
-	client := &http.Client{}
+// fetch sends a single request.
+func fetch(url string, checkStatus bool) ([]byte, error) {
 	ua := popua.GetWeightedRandom()
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
@@ -370,12 +349,14 @@ func request(url string, checkStatus bool) ([]byte, error) {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", ua)
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		log.Errorw("http request send failed", zap.Error(err), zap.String("url", url))
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", errUpstreamUnavailable, err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: http status %v", errUpstreamUnavailable, resp.StatusCode)
+	}
 	if resp.StatusCode != 200 {
 		if checkStatus {
 			log.Errorw("http response code check failed", zap.Int("status", resp.StatusCode), zap.String("url", url))
@@ -383,9 +364,9 @@ func request(url string, checkStatus bool) ([]byte, error) {
 		return nil, errors.New("check http status code failed")
 	}
 	content, err := io.ReadAll(resp.Body)
+	crawlBudget.consume(len(content))
 	if err != nil {
-		log.Errorw("load resp body failed", zap.Error(err), zap.String("url", url))
-		return nil, err
+		return nil, fmt.Errorf("%w: load resp body: %v", errUpstreamUnavailable, err)
 	}
 	return content, err
 }
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const mergeReportFile = "merge_report.json"
+
+// which version of a record merge keeps
+const (
+	preferComplete = "complete" // most specials, then most scores, then newest
+	preferNewest   = "newest"   // newest, then most complete
+)
+
+// MergeReport is written to merge_report.json next to the merged output.
+type MergeReport struct {
+	CreatedAt         time.Time
+	Prefer            string
+	Inputs            []MergeInput
+	Records           int // year/type/batch records written
+	DuplicateSpecials int // specials dropped because a record listed them twice
+	PTBLines          int
+	Schools           int
+	Conflicts         []MergeConflict
+}
+
+type MergeInput struct {
+	Dir     string
+	RunAt   time.Time // from run_report.json, zero without one and file times are used
+	Records int       // year/type/batch records read
+	Kept    int       // records of this input in the merged output
+}
+
+// MergeConflict is a special whose scores differ between inputs.
+type MergeConflict struct {
+	SchoolID    string
+	ProvinceID  string
+	Year        string
+	Type        string
+	Batch       string
+	SpecialID   string
+	SpecialName string
+	Kept        string // run of the kept version
+	Versions    []MergeVersion
+}
+
+type MergeVersion struct {
+	Run        string
+	Min        NullDecimal
+	Average    NullDecimal
+	Max        NullDecimal
+	MinSection NullInt
+}
+
+// mergeCandidate is one version of a year/type/batch record of a school and province.
+type mergeCandidate struct {
+	input        int
+	provinceName CodeName
+	ytb          YTBSpecial
+}
+
+func (c mergeCandidate) runAt() time.Time {
+	return c.ytb.Provenance.RunAt
+}
+
+// completeness counts specials and the scores they have.
+func (c mergeCandidate) completeness() (specials, scores int) {
+	for _, s := range c.ytb.Special {
+		for _, valid := range []bool{s.Min.Valid, s.Average.Valid, s.Max.Valid, s.MinSection.Valid} {
+			if valid {
+				scores++
+			}
+		}
+	}
+	return len(c.ytb.Special), scores
+}
+
+// better reports whether c is preferred over o.
+func (c mergeCandidate) better(o mergeCandidate, prefer string) bool {
+	specials, scores := c.completeness()
+	otherSpecials, otherScores := o.completeness()
+	newer, older := c.runAt().After(o.runAt()), c.runAt().Before(o.runAt())
+	// a record known to miss specials loses either way
+	if c.ytb.Provenance.Partial != o.ytb.Provenance.Partial {
+		return !c.ytb.Provenance.Partial
+	}
+	if prefer == preferNewest && (newer || older) {
+		return newer
+	}
+	if specials != otherSpecials {
+		return specials > otherSpecials
+	}
+	if scores != otherScores {
+		return scores > otherScores
+	}
+	return newer
+}
+
+// specialIdentity identifies a special within a year/type/batch record, the name is
+// part of it since specials without ids share an empty key.
+func specialIdentity(s TypedSpecial) string {
+	return s.key() + "|" + s.Spname
+}
+
+func sameScores(a, b TypedSpecial) bool {
+	same := func(x, y NullDecimal) bool { return !x.Valid || !y.Valid || x.Float == y.Float }
+	return same(a.Min, b.Min) && same(a.Average, b.Average) && same(a.Max, b.Max) &&
+		(!a.MinSection.Valid || !b.MinSection.Valid || a.MinSection.Int == b.MinSection.Int)
+}
+
+type merger struct {
+	prefer string
+	out    string
+	report *MergeReport
+}
+
+// runTime reads when a crawl output was produced from its run report, zero without one.
+func runTime(dir string) time.Time {
+	content, err := os.ReadFile(path.Join(dir, runReportFile))
+	if err != nil {
+		return time.Time{}
+	}
+	var r struct {
+		StartedAt  time.Time
+		FinishedAt time.Time
+	}
+	if err := json.Unmarshal(content, &r); err != nil {
+		return time.Time{}
+	}
+	if !r.FinishedAt.IsZero() {
+		return r.FinishedAt
+	}
+	return r.StartedAt
+}
+
+// sourceTime is the modification time of the file a record was read from.
+func sourceTime(source string) time.Time {
+	file := source
+	if i := strings.LastIndex(source, ":"); i > 0 && jsonlPartName.MatchString(path.Base(source[:i])) {
+		file = source[:i]
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return stat.ModTime()
+}
+
+// mergeProvince merges the special_detail records of a province from every input.
+func (m *merger) mergeProvince(province string) error {
+	// school -> year/type/batch -> versions
+	candidates := make(map[string]map[string][]mergeCandidate)
+	onlyProvince := func(key string) bool { return provinceOfKey(key) == province }
+	for i := range m.report.Inputs {
+		in := &m.report.Inputs[i]
+		err := walkSpecialDetail(path.Join(in.Dir, specialDetailDir), onlyProvince, func(file string, sp SchoolProv) error {
+			if candidates[sp.SchoolID] == nil {
+				candidates[sp.SchoolID] = make(map[string][]mergeCandidate)
+			}
+			for _, ytb := range sp.YTBSpecials {
+				// records of a merged output keep the run they came from
+				if ytb.Provenance == nil {
+					at := in.RunAt
+					if at.IsZero() {
+						at = sourceTime(file)
+					}
+					ytb.Provenance = &Provenance{Run: in.Dir, RunAt: at, Source: file}
+				}
+				// duplicates would count as completeness
+				ytb.Special = m.dedupe(ytb.Special)
+				key := strings.Join([]string{ytb.Year, ytb.Typ, ytb.Batch}, "_")
+				candidates[sp.SchoolID][key] = append(candidates[sp.SchoolID][key], mergeCandidate{input: i, provinceName: sp.ProvinceName, ytb: ytb})
+				in.Records++
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for schoolID, ytbs := range candidates {
+		sp := SchoolProv{SchoolID: schoolID, ProvinceID: province, YTBSpecials: make([]YTBSpecial, 0, len(ytbs))}
+		for _, versions := range ytbs {
+			best := versions[0]
+			for _, c := range versions[1:] {
+				if c.better(best, m.prefer) {
+					best = c
+				}
+			}
+			m.conflicts(schoolID, province, best, versions)
+			sp.ProvinceName = best.provinceName
+			sp.YTBSpecials = append(sp.YTBSpecials, best.ytb)
+			m.report.Inputs[best.input].Kept++
+			m.report.Records++
+		}
+		sort.Slice(sp.YTBSpecials, func(i, j int) bool {
+			x, y := sp.YTBSpecials[i], sp.YTBSpecials[j]
+			if x.Year != y.Year {
+				return x.Year < y.Year
+			}
+			if x.Typ != y.Typ {
+				return lessID(x.Typ, y.Typ)
+			}
+			return lessID(x.Batch, y.Batch)
+		})
+		if err := writeJSON(path.Join(m.out, specialDetailDir, schoolID+"_"+province+".json"), sp); err != nil {
+			return err
+		}
+		// groups of the inputs are not merged, their records may have been replaced
+		if err := writeSpecialGroups(fileSink{}, path.Join(m.out, specialGroupDir), sp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupe drops repeated specials of a record, keeping the one with most scores.
+func (m *merger) dedupe(specials []TypedSpecial) []TypedSpecial {
+	res := make([]TypedSpecial, 0, len(specials))
+	index := make(map[string]int, len(specials))
+	for _, s := range specials {
+		i, ok := index[specialIdentity(s)]
+		if !ok {
+			index[specialIdentity(s)] = len(res)
+			res = append(res, s)
+			continue
+		}
+		m.report.DuplicateSpecials++
+		_, kept := mergeCandidate{ytb: YTBSpecial{Special: res[i : i+1]}}.completeness()
+		_, dup := mergeCandidate{ytb: YTBSpecial{Special: []TypedSpecial{s}}}.completeness()
+		if dup > kept {
+			res[i] = s
+		}
+	}
+	return res
+}
+
+// conflicts reports specials whose scores differ between the versions of a record.
+func (m *merger) conflicts(schoolID, province string, best mergeCandidate, versions []mergeCandidate) {
+	if len(versions) < 2 {
+		return
+	}
+	// special -> version of each input listing it
+	bySpecial := make(map[string][]TypedSpecial)
+	runs := make(map[string][]string)
+	order := make([]string, 0)
+	for _, c := range versions {
+		for _, s := range c.ytb.Special {
+			id := specialIdentity(s)
+			if _, ok := bySpecial[id]; !ok {
+				order = append(order, id)
+			}
+			bySpecial[id] = append(bySpecial[id], s)
+			runs[id] = append(runs[id], c.ytb.Provenance.Run)
+		}
+	}
+	for _, id := range order {
+		specials := bySpecial[id]
+		conflict := false
+		for _, s := range specials[1:] {
+			if !sameScores(specials[0], s) {
+				conflict = true
+				break
+			}
+		}
+		if !conflict {
+			continue
+		}
+		c := MergeConflict{
+			SchoolID:    schoolID,
+			ProvinceID:  province,
+			Year:        best.ytb.Year,
+			Type:        best.ytb.Typ,
+			Batch:       best.ytb.Batch,
+			SpecialID:   specials[0].SpecialID,
+			SpecialName: specials[0].Spname,
+			Kept:        best.ytb.Provenance.Run,
+		}
+		for i, s := range specials {
+			c.Versions = append(c.Versions, MergeVersion{Run: runs[id][i], Min: s.Min, Average: s.Average, Max: s.Max, MinSection: s.MinSection})
+		}
+		m.report.Conflicts = append(m.report.Conflicts, c)
+	}
+}
+
+// mergePTB joins the ptb.txt of every input, a plan listed by several is kept once.
+func (m *merger) mergePTB() error {
+	out, err := os.Create(path.Join(m.out, schoolPTBFile))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	seen := make(map[string]bool)
+	for _, in := range m.report.Inputs {
+		file := path.Join(in.Dir, schoolPTBFile)
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			// year, school, prov, type, batch
+			fields := strings.Split(scanner.Text(), ",")
+			if len(fields) < 5 {
+				log.Warnw("ptb line skipped", zap.String("file", file), zap.Int("line", line))
+				continue
+			}
+			key := strings.Join(fields[:5], ",")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			fmt.Fprintln(w, scanner.Text())
+			m.report.PTBLines++
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %w", file, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// mergeSchoolLists joins the school lists of every input, the newest input names a school.
+func (m *merger) mergeSchoolLists() error {
+	inputs := make([]MergeInput, len(m.report.Inputs))
+	copy(inputs, m.report.Inputs)
+	sort.SliceStable(inputs, func(i, j int) bool { return inputs[i].RunAt.Before(inputs[j].RunAt) })
+	names := make(map[string]string)
+	for _, in := range inputs {
+		list, err := loadSchoolList(path.Join(in.Dir, schoolListFile))
+		if err != nil {
+			return err
+		}
+		for _, sc := range list {
+			names[sc.SchoolID] = firstNonEmpty(sc.Name, names[sc.SchoolID])
+		}
+	}
+	merged := school{Data: make([]schoolData, 0, len(names))}
+	for id, name := range names {
+		merged.Data = append(merged.Data, schoolData{SchoolID: id, Name: name})
+	}
+	sort.Slice(merged.Data, func(i, j int) bool { return lessID(merged.Data[i].SchoolID, merged.Data[j].SchoolID) })
+	m.report.Schools = len(merged.Data)
+	return writeJSON(path.Join(m.out, schoolListFile), merged)
+}
+
+// mergeOutputs merges partial crawl outputs into out record by record.
+func mergeOutputs(dirs []string, out, prefer string) (*MergeReport, error) {
+	m := &merger{prefer: prefer, out: out, report: &MergeReport{
+		CreatedAt: time.Now(),
+		Prefer:    prefer,
+		Inputs:    make([]MergeInput, 0, len(dirs)),
+		Conflicts: make([]MergeConflict, 0),
+	}}
+	seen := make(map[string]bool)
+	provinces := make([]string, 0)
+	for _, dir := range dirs {
+		m.report.Inputs = append(m.report.Inputs, MergeInput{Dir: dir, RunAt: runTime(dir)})
+		keys, err := datasetKeys(path.Join(dir, specialDetailDir))
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if prov := provinceOfKey(key); !seen[prov] {
+				seen[prov] = true
+				provinces = append(provinces, prov)
+			}
+		}
+	}
+	sort.Slice(provinces, func(i, j int) bool { return lessID(provinces[i], provinces[j]) })
+	for _, dir := range []string{specialDetailDir, specialGroupDir} {
+		if err := os.MkdirAll(path.Join(out, dir), 0777); err != nil {
+			return nil, err
+		}
+	}
+	for _, prov := range provinces {
+		if err := m.mergeProvince(prov); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(m.report.Conflicts, func(i, j int) bool {
+		x, y := m.report.Conflicts[i], m.report.Conflicts[j]
+		if x.ProvinceID != y.ProvinceID {
+			return lessID(x.ProvinceID, y.ProvinceID)
+		}
+		if x.SchoolID != y.SchoolID {
+			return lessID(x.SchoolID, y.SchoolID)
+		}
+		return x.Year+x.Type+x.Batch+x.SpecialName < y.Year+y.Type+y.Batch+y.SpecialName
+	})
+	if err := m.mergePTB(); err != nil {
+		return nil, err
+	}
+	if err := m.mergeSchoolLists(); err != nil {
+		return nil, err
+	}
+	return m.report, writeJSON(path.Join(out, mergeReportFile), m.report)
+}
+
+// mergeCommand merges several partial crawl outputs into one.
+func mergeCommand(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("out", "merged", "output directory, must not hold a special_detail/ or special_group/ yet")
+	prefer := fs.String("prefer", preferComplete, "version of a record to keep: complete or newest")
+	must(fs.Parse(args))
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: merge [flags] <crawl output>...")
+	}
+	if *prefer != preferComplete && *prefer != preferNewest {
+		return fmt.Errorf("unknown -prefer %q", *prefer)
+	}
+	for _, dir := range []string{specialDetailDir, specialGroupDir} {
+		if entries, err := os.ReadDir(path.Join(*out, dir)); err == nil && len(entries) != 0 {
+			return fmt.Errorf("%v already has %v/, merge into an empty directory", *out, dir)
+		}
+	}
+	for _, dir := range fs.Args() {
+		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+			return fmt.Errorf("crawl output %v is not a directory", dir)
+		}
+	}
+	r, err := mergeOutputs(fs.Args(), *out, *prefer)
+	if err != nil {
+		return err
+	}
+	log.Infow("merged",
+		zap.String("out", *out),
+		zap.Int("inputs", len(r.Inputs)),
+		zap.Int("records", r.Records),
+		zap.Int("duplicate specials", r.DuplicateSpecials),
+		zap.Int("ptb lines", r.PTBLines),
+		zap.Int("conflicts", len(r.Conflicts)))
+	return nil
+}
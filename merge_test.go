@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testSpecial is a special with the given scores, a zero score is missing.
+func testSpecial(name string, min float64, rank int64) TypedSpecial {
+	s := TypedSpecial{Special: Special{SpecialID: name, Spname: name}}
+	s.Min = NullDecimal{min, min != 0}
+	s.MinSection = NullInt{rank, rank != 0}
+	return s
+}
+
+func testCandidate(run string, at time.Time, partial bool, specials ...TypedSpecial) mergeCandidate {
+	return mergeCandidate{ytb: YTBSpecial{Year: "2023", Typ: "1", Batch: "7", Special: specials,
+		Provenance: &Provenance{Run: run, RunAt: at, Partial: partial}}}
+}
+
+func TestMergeCompleteness(t *testing.T) {
+	c := testCandidate("a", time.Time{}, false, testSpecial("数学", 600, 3000), testSpecial("物理", 0, 3100), testSpecial("法学", 0, 0))
+	if specials, scores := c.completeness(); specials != 3 || scores != 3 {
+		t.Errorf("completeness %v specials %v scores, want 3 and 3", specials, scores)
+	}
+}
+
+func TestMergeBetter(t *testing.T) {
+	older, newer := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	math, phys := testSpecial("数学", 600, 3000), testSpecial("物理", 590, 3500)
+	for _, c := range []struct {
+		name   string
+		c, o   mergeCandidate
+		prefer string
+		want   bool
+	}{
+		{"more specials", testCandidate("a", older, false, math, phys), testCandidate("b", newer, false, math), preferComplete, true},
+		{"fewer specials", testCandidate("a", newer, false, math), testCandidate("b", older, false, math, phys), preferComplete, false},
+		{"more scores", testCandidate("a", older, false, math), testCandidate("b", newer, false, testSpecial("数学", 600, 0)), preferComplete, true},
+		{"as complete and newer", testCandidate("a", newer, false, math), testCandidate("b", older, false, math), preferComplete, true},
+		{"as complete and older", testCandidate("a", older, false, math), testCandidate("b", newer, false, math), preferComplete, false},
+		{"newest wins", testCandidate("a", newer, false, math), testCandidate("b", older, false, math, phys), preferNewest, true},
+		{"newest, same time", testCandidate("a", newer, false, math, phys), testCandidate("b", newer, false, math), preferNewest, true},
+		{"partial loses", testCandidate("a", newer, true, math, phys), testCandidate("b", older, false, math), preferComplete, false},
+		{"partial loses when newest", testCandidate("a", newer, true, math), testCandidate("b", older, false, math), preferNewest, false},
+	} {
+		if got := c.c.better(c.o, c.prefer); got != c.want {
+			t.Errorf("%v: better %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	at := time.Now()
+	m := &merger{report: &MergeReport{Conflicts: make([]MergeConflict, 0)}}
+	a := testCandidate("a", at, false, testSpecial("数学", 600, 3000), testSpecial("物理", 590, 3500), testSpecial("法学", 580, 0))
+	b := testCandidate("b", at, false, testSpecial("数学", 600, 3000), testSpecial("物理", 595, 3500), testSpecial("法学", 580, 4000))
+	m.conflicts("31", "45", a, []mergeCandidate{a, b})
+	// a score missing on one side is no conflict
+	if len(m.report.Conflicts) != 1 {
+		t.Fatalf("conflicts %+v, want 物理 only", m.report.Conflicts)
+	}
+	c := m.report.Conflicts[0]
+	if c.SpecialName != "物理" || c.Kept != "a" || len(c.Versions) != 2 || c.Versions[1].Run != "b" || c.Versions[1].Min.Float != 595 {
+		t.Errorf("conflict %+v", c)
+	}
+
+	m.conflicts("31", "45", a, []mergeCandidate{a})
+	if len(m.report.Conflicts) != 1 {
+		t.Error("conflict within a single version")
+	}
+}
+
+func TestMergeRebuildsGroups(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	write := func(run string, specials ...TypedSpecial) string {
+		in := filepath.Join(dir, run)
+		os.MkdirAll(filepath.Join(in, specialDetailDir), 0777)
+		sp := SchoolProv{SchoolID: "31", ProvinceID: "45", YTBSpecials: []YTBSpecial{{Year: "2023", Typ: "2073", Batch: "14", Special: specials}}}
+		if err := writeJSON(filepath.Join(in, specialDetailDir, "31_45.json"), sp); err != nil {
+			t.Fatal(err)
+		}
+		return in
+	}
+	grouped := func(s TypedSpecial, group string) TypedSpecial {
+		s.SpecialGroup = group
+		return s
+	}
+	// the first run misses a member of group 2
+	a := write("a", grouped(testSpecial("数学", 600, 3000), "1"), grouped(testSpecial("物理", 590, 3500), "2"))
+	b := write("b", grouped(testSpecial("数学", 600, 3000), "1"), grouped(testSpecial("物理", 590, 3500), "2"), grouped(testSpecial("化学", 580, 4200), "2"))
+
+	out := filepath.Join(dir, "merged")
+	if _, err := mergeOutputs([]string{a, b}, out, preferComplete); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(out, specialGroupDir, "31_45.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups SchoolProvGroups
+	if err := json.Unmarshal(content, &groups); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups.Groups) != 2 || len(groups.Groups[1].Members) != 2 || groups.Groups[1].MinSection.Int != 4200 {
+		t.Errorf("groups not rebuilt from the merged specials: %+v", groups.Groups)
+	}
+}
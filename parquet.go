@@ -0,0 +1,295 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"go.uber.org/zap"
+)
+
+const (
+	parquetDir = "parquet"
+	// parquetSchemaVersion is stored in the footer of every file, bump it when columns
+	// change incompatibly.
+	parquetSchemaVersion = "1"
+)
+
+// parquetSpecial is a row of the special_detail dataset, written to
+// special_detail/year=<year>/province=<province>/part-0.parquet. year and province are
+// partition columns, they live in the path only.
+type parquetSpecial struct {
+	SchoolID       string   `parquet:"name=school_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SchoolName     string   `parquet:"name=school_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Type           string   `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TypeName       string   `parquet:"name=type_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Batch          string   `parquet:"name=batch, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BatchName      string   `parquet:"name=batch_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SpecialID      string   `parquet:"name=special_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SpeID          string   `parquet:"name=spe_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SpecialName    string   `parquet:"name=special_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SpecialGroup   string   `parquet:"name=special_group, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SgName         string   `parquet:"name=sg_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Zslx           string   `parquet:"name=zslx, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ZslxName       string   `parquet:"name=zslx_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	LocalBatchName string   `parquet:"name=local_batch_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Level1Name     string   `parquet:"name=level1_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Level2Name     string   `parquet:"name=level2_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Level3Name     string   `parquet:"name=level3_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Max            *float64 `parquet:"name=max, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Min            *float64 `parquet:"name=min, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Average        *float64 `parquet:"name=average, type=DOUBLE, repetitiontype=OPTIONAL"`
+	MinSection     *int64   `parquet:"name=min_section, type=INT64, repetitiontype=OPTIONAL"`
+}
+
+// parquetSchool is a row of the school dataset, written to school/part-0.parquet.
+type parquetSchool struct {
+	SchoolID          string `parquet:"name=school_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name              string `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OldName           string `parquet:"name=old_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Is985             bool   `parquet:"name=is_985, type=BOOLEAN"`
+	Is211             bool   `parquet:"name=is_211, type=BOOLEAN"`
+	IsDualClass       bool   `parquet:"name=is_dual_class, type=BOOLEAN"`
+	DualClassSubjects string `parquet:"name=dual_class_subjects, type=BYTE_ARRAY, convertedtype=UTF8"` // names joined by ","
+	Belong            string `parquet:"name=belong, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CityID            string `parquet:"name=city_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CityName          string `parquet:"name=city_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	LevelName         string `parquet:"name=level_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	NatureName        string `parquet:"name=nature_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SchoolTypeName    string `parquet:"name=school_type_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TypeName          string `parquet:"name=type_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	NumAcademician    *int64 `parquet:"name=num_academician, type=INT64, repetitiontype=OPTIONAL"`
+	NumDoctor         *int64 `parquet:"name=num_doctor, type=INT64, repetitiontype=OPTIONAL"`
+	NumMaster         *int64 `parquet:"name=num_master, type=INT64, repetitiontype=OPTIONAL"`
+	NumSubject        *int64 `parquet:"name=num_subject, type=INT64, repetitiontype=OPTIONAL"`
+	QsRank            *int64 `parquet:"name=qs_rank, type=INT64, repetitiontype=OPTIONAL"`
+	QsWorld           *int64 `parquet:"name=qs_world, type=INT64, repetitiontype=OPTIONAL"`
+	RuankeRank        *int64 `parquet:"name=ruanke_rank, type=INT64, repetitiontype=OPTIONAL"`
+}
+
+func (n NullInt) ptr() *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int
+}
+
+func (n NullDecimal) ptr() *float64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Float
+}
+
+// writeParquet writes rows, a slice of parquet structs, into file with the schema
+// version and dataset name in the footer.
+func writeParquet(file, dataset string, rows []interface{}, obj interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pw, err := writer.NewParquetWriterFromWriter(f, obj, 1)
+	if err != nil {
+		return err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	for key, value := range map[string]string{
+		"gk-score.schema_version": parquetSchemaVersion,
+		"gk-score.dataset":        dataset,
+		"gk-score.dict_version":   provinceDict.Version,
+	} {
+		value := value
+		pw.Footer.KeyValueMetadata = append(pw.Footer.KeyValueMetadata, &parquet.KeyValue{Key: key, Value: &value})
+	}
+	for _, row := range rows {
+		if err := pw.Write(row); err != nil {
+			return fmt.Errorf("%v: %w", file, err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		return fmt.Errorf("%v: %w", file, err)
+	}
+	return f.Close()
+}
+
+// exportParquetSpecials writes special_detail/ partitioned by year and province. records
+// are read one province at a time, so only one province is held in memory.
+func exportParquetSpecials(dir, out string) (int, error) {
+	detailDir := filepath.Join(dir, specialDetailDir)
+	keys, err := datasetKeys(detailDir)
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool)
+	provinces := make([]string, 0)
+	for _, key := range keys {
+		if prov := provinceOfKey(key); !seen[prov] {
+			seen[prov] = true
+			provinces = append(provinces, prov)
+		}
+	}
+	sort.Slice(provinces, func(i, j int) bool { return lessID(provinces[i], provinces[j]) })
+
+	total := 0
+	for _, prov := range provinces {
+		byYear := make(map[int][]interface{})
+		onlyProvince := func(key string) bool { return provinceOfKey(key) == prov }
+		err := walkSpecialDetail(detailDir, onlyProvince, func(file string, sp SchoolProv) error {
+			for _, ytb := range sp.YTBSpecials {
+				year, _ := strconv.Atoi(ytb.Year)
+				for _, s := range ytb.Special {
+					a := admissionScoreFromSpecial(s, sp.SchoolID, sp.ProvinceID, year, ytb.Typ, ytb.Batch)
+					byYear[year] = append(byYear[year], &parquetSpecial{
+						SchoolID:       a.SchoolID,
+						SchoolName:     a.SchoolName,
+						Type:           a.Type,
+						TypeName:       ytb.TypName.ZH,
+						Batch:          a.Batch,
+						BatchName:      ytb.BatchName.ZH,
+						SpecialID:      a.SpecialID,
+						SpeID:          a.SpeID,
+						SpecialName:    a.SpecialName,
+						SpecialGroup:   a.SpecialGroup,
+						SgName:         s.SgName,
+						Zslx:           a.Zslx,
+						ZslxName:       a.ZslxName,
+						LocalBatchName: a.LocalBatchName,
+						Level1Name:     a.Level1Name,
+						Level2Name:     a.Level2Name,
+						Level3Name:     a.Level3Name,
+						Max:            a.Max.ptr(),
+						Min:            a.Min.ptr(),
+						Average:        a.Average.ptr(),
+						MinSection:     a.MinSection.ptr(),
+					})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		for year, rows := range byYear {
+			file := filepath.Join(out, specialDetailDir, fmt.Sprintf("year=%v", year), "province="+prov, "part-0.parquet")
+			if err := writeParquet(file, specialDetailDir, rows, new(parquetSpecial)); err != nil {
+				return total, err
+			}
+			total += len(rows)
+		}
+	}
+	return total, nil
+}
+
+// exportParquetSchools writes the school profiles into one file.
+func exportParquetSchools(dir, out string) (int, error) {
+	profiles, err := loadSchoolProfiles(filepath.Join(dir, schoolInfoDir))
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]string, 0, len(profiles))
+	for id := range profiles {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return lessID(ids[i], ids[j]) })
+	rows := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		p := profiles[id]
+		subjects := make([]string, 0, len(p.DualClassSubjects))
+		for _, s := range p.DualClassSubjects {
+			subjects = append(subjects, s.Name)
+		}
+		rows = append(rows, &parquetSchool{
+			SchoolID:          p.SchoolID,
+			Name:              p.Name,
+			OldName:           p.OldName,
+			Is985:             p.Is985,
+			Is211:             p.Is211,
+			IsDualClass:       p.IsDualClass,
+			DualClassSubjects: strings.Join(subjects, ","),
+			Belong:            p.Belong,
+			CityID:            p.CityID,
+			CityName:          p.CityName,
+			LevelName:         p.LevelName,
+			NatureName:        p.NatureName,
+			SchoolTypeName:    p.SchoolTypeName,
+			TypeName:          p.TypeName,
+			NumAcademician:    p.NumAcademician.ptr(),
+			NumDoctor:         p.NumDoctor.ptr(),
+			NumMaster:         p.NumMaster.ptr(),
+			NumSubject:        p.NumSubject.ptr(),
+			QsRank:            p.QsRank.ptr(),
+			QsWorld:           p.QsWorld.ptr(),
+			RuankeRank:        p.RuankeRank.ptr(),
+		})
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return len(rows), writeParquet(filepath.Join(out, "school", "part-0.parquet"), "school", rows, new(parquetSchool))
+}
+
+// parquetCommand exports the special detail and school datasets of a crawl output.
+func parquetCommand(args []string) error {
+	fs := flag.NewFlagSet("parquet", flag.ExitOnError)
+	dir := fs.String("dir", ".", "crawl output directory")
+	out := fs.String("out", parquetDir, "output directory, its dataset directories are replaced")
+	must(fs.Parse(args))
+	absDir, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+	absOut, err := filepath.Abs(*out)
+	if err != nil {
+		return err
+	}
+	if absDir == absOut {
+		// its datasets would replace the special_detail/ they are read from
+		return fmt.Errorf("parquet output %v is the crawl output directory, choose another -out", *out)
+	}
+	// build the datasets aside and move them into place once complete
+	if err := os.MkdirAll(*out, 0777); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(*out, ".parquet-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	loadSchoolNames(filepath.Join(*dir, schoolListFile))
+	schools, err := exportParquetSchools(*dir, tmp)
+	if err != nil {
+		return err
+	}
+	specials, err := exportParquetSpecials(*dir, tmp)
+	if err != nil {
+		return err
+	}
+	// stale partitions of an earlier export would be read as part of the dataset
+	for _, dataset := range []string{"school", specialDetailDir} {
+		if err := os.RemoveAll(filepath.Join(*out, dataset)); err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(tmp, dataset)); err != nil {
+			continue
+		}
+		if err := os.Rename(filepath.Join(tmp, dataset), filepath.Join(*out, dataset)); err != nil {
+			return err
+		}
+	}
+	log.Infow("parquet exported",
+		zap.String("dir", *out),
+		zap.String("schema version", parquetSchemaVersion),
+		zap.Int("schools", schools),
+		zap.Int("specials", specials))
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const specialPlanDir = "special_plan"
+
+// looseString decodes json strings, numbers and null alike, upstream is not consistent
+// about quoting numbers of the plan endpoint.
+type looseString string
+
+func (s *looseString) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*s = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var v string
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*s = looseString(v)
+		return nil
+	}
+	*s = looseString(bytes.TrimSpace(data))
+	return nil
+}
+
+// SchoolPlan is a page of schoolplanindex.
+type SchoolPlan struct {
+	Data struct {
+		NumFound int        `json:"numFound"`
+		Item     []PlanItem `json:"item"`
+	} `json:"data"`
+}
+
+// PlanItem is one special of the 招生计划.
+type PlanItem struct {
+	SchoolID       looseString `json:"school_id"`
+	SpecialID      looseString `json:"special_id"`
+	SpeID          looseString `json:"spe_id"`
+	Spname         looseString `json:"spname"`
+	Spcode         looseString `json:"spcode"`
+	Num            looseString `json:"num"`     // 计划人数
+	Length         looseString `json:"length"`  // 学制
+	Tuition        looseString `json:"tuition"` // 学费
+	Info           looseString `json:"info"`
+	SpInfo         looseString `json:"sp_info"`
+	ZslxName       looseString `json:"zslx_name"`
+	LocalBatchName looseString `json:"local_batch_name"`
+	Level2Name     looseString `json:"level2_name"`
+	Level3Name     looseString `json:"level3_name"`
+	SpecialGroup   looseString `json:"special_group"`
+	SgName         looseString `json:"sg_name"`
+}
+
+// PlanRecord is a plan item with parsed seats and tuition.
+type PlanRecord struct {
+	PlanItem
+	Seats    NullInt     `json:"seats"`
+	Tuition  NullDecimal `json:"tuition_yuan"` // per year
+	Duration string      `json:"duration"`
+	Notes    string      `json:"notes"`
+	Warnings []string    `json:"warnings,omitempty"`
+}
+
+type YTBPlan struct {
+	Year      string
+	Typ       string
+	TypName   CodeName
+	Batch     string
+	BatchName CodeName
+	Plans     []PlanRecord
+}
+
+// SchoolProvPlan is written to special_plan/<school>_<province>.json, parallel to special_detail/.
+type SchoolProvPlan struct {
+	SchoolID     string
+	ProvinceID   string
+	ProvinceName CodeName
+	YTBPlans     []YTBPlan
+}
+
+var leadingNumber = regexp.MustCompile(`^\d+(\.\d+)?`)
+
+// Record parses seats and tuition, tuition like "5000元/年" keeps its leading number.
+func (p PlanItem) Record() PlanRecord {
+	r := PlanRecord{PlanItem: p, Duration: string(p.Length), Notes: string(p.Info)}
+	seats, err := parseNullInt(string(p.Num))
+	if err != nil {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("num: %v", err))
+	}
+	r.Seats = seats
+	tuition := strings.TrimSpace(string(p.Tuition))
+	if n := leadingNumber.FindString(tuition); n != "" {
+		tuition = n
+	}
+	if r.Tuition, err = parseNullDecimal(tuition); err != nil {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("tuition: %v", err))
+	}
+	return r
+}
+
+// specialPlanEndpoint is the 招生计划, stored like special_detail/.
+var specialPlanEndpoint = &endpoint{
+	Stage:     stageSpecialPlan,
+	URLFormat: "https://static-data.gaokao.cn/www/2.0/schoolplanindex/%v/%v/%v/%v/%v/%v.json",
+	Dims:      ytbDims,
+	Paging:    pageIndex,
+	Response:  SchoolPlan{},
+	Dir:       specialPlanDir,
+	Build: func(task endpointTask, responses []endpointResponse) (interface{}, error) {
+		plan := SchoolProvPlan{
+			SchoolID:     task.Key[0],
+			ProvinceID:   task.Key[1],
+			ProvinceName: provinceDict.name(task.Key[1]),
+			YTBPlans:     make([]YTBPlan, 0, len(responses)),
+		}
+		for _, resp := range responses {
+			var items []PlanItem
+			if err := decodeItems(resp.Items, &items); err != nil {
+				return nil, err
+			}
+			plans := make([]PlanRecord, 0, len(items))
+			for _, item := range items {
+				plans = append(plans, item.Record())
+			}
+			year, typ, batch := ytbOf(resp.Args)
+			plan.YTBPlans = append(plan.YTBPlans, YTBPlan{
+				Year:      year,
+				Typ:       typ,
+				TypName:   typeDict.name(typ),
+				Batch:     batch,
+				BatchName: batchDict.name(batch),
+				Plans:     plans,
+			})
+		}
+		return plan, nil
+	},
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bzssm/goclub/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// query output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// scoreQuery selects specials of a snapshot, empty fields do not filter.
+type scoreQuery struct {
+	Province string
+	Schools  []string // ids
+	Major    string   // special id, or part of the special name
+	From, To int
+	Type     string
+	Batch    string
+	Zslx     string // zslx_name, e.g. 普通类
+}
+
+// QueryRow is a special of a school in a year, as printed by query.
+type QueryRow struct {
+	Year        int
+	SchoolID    string
+	SchoolName  string
+	ProvinceID  string
+	Type        string
+	Batch       string
+	ZslxName    string
+	SpecialName string
+	Min         NullDecimal
+	Average     NullDecimal
+	Max         NullDecimal
+	MinSection  NullInt
+}
+
+// openQueryIndex opens the sqlite index of a snapshot. it is exported on first use and
+// again when the files it was built from changed, or when rebuild is set.
+func openQueryIndex(dir, index string, rebuild bool) (*sql.DB, error) {
+	if _, err := os.Stat(index); err != nil {
+		rebuild = true
+	}
+	if !rebuild {
+		fingerprint, err := sourceFingerprint(dir)
+		if err != nil {
+			return nil, err
+		}
+		rebuild = indexFingerprint(index) != fingerprint
+	}
+	if rebuild {
+		log.Infow("building query index", zap.String("file", index))
+		if err := exportSQLite(dir, index); err != nil {
+			return nil, err
+		}
+	}
+	return sql.Open("sqlite", index)
+}
+
+// indexFingerprint reads the source fingerprint stored in an index, empty when it has none.
+func indexFingerprint(index string) string {
+	db, err := sql.Open("sqlite", index)
+	if err != nil {
+		return ""
+	}
+	defer db.Close()
+	var fingerprint string
+	if err := db.QueryRow(`SELECT value FROM meta WHERE key = 'fingerprint'`).Scan(&fingerprint); err != nil {
+		return ""
+	}
+	return fingerprint
+}
+
+// resolveSchools turns a school id or part of a school name into school ids, names are
+// looked up in the school profiles and the school list.
+func resolveSchools(db *sql.DB, dir, school string) ([]string, error) {
+	if _, err := strconv.Atoi(school); err == nil {
+		return []string{school}, nil
+	}
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+	rows, err := db.Query(`SELECT school_id FROM schools WHERE name LIKE ?`, "%"+school+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	list, err := loadSchoolList(path.Join(dir, schoolListFile))
+	if err != nil {
+		return nil, err
+	}
+	for _, sc := range list {
+		if strings.Contains(sc.Name, school) && !seen[sc.SchoolID] {
+			seen[sc.SchoolID] = true
+			ids = append(ids, sc.SchoolID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no school matches %q", school)
+	}
+	return ids, nil
+}
+
+func (q scoreQuery) sql() (string, []interface{}) {
+	where := []string{"s.year BETWEEN ? AND ?"}
+	args := []interface{}{q.From, q.To}
+	if q.Province != "" {
+		where = append(where, "s.province_id = ?")
+		args = append(args, q.Province)
+	}
+	if len(q.Schools) != 0 {
+		where = append(where, "s.school_id IN (?"+strings.Repeat(", ?", len(q.Schools)-1)+")")
+		for _, id := range q.Schools {
+			args = append(args, id)
+		}
+	}
+	if q.Major != "" {
+		if _, err := strconv.Atoi(q.Major); err == nil {
+			where = append(where, "s.special_id = ?")
+			args = append(args, q.Major)
+		} else {
+			where = append(where, "s.special_name LIKE ?")
+			args = append(args, "%"+q.Major+"%")
+		}
+	}
+	for _, f := range []struct {
+		column, value string
+	}{{"s.type", q.Type}, {"s.batch", q.Batch}, {"s.zslx_name", q.Zslx}} {
+		if f.value != "" {
+			where = append(where, f.column+" = ?")
+			args = append(args, f.value)
+		}
+	}
+	return `SELECT s.year, s.school_id, COALESCE(sc.name, ''), s.province_id, s.type, s.batch,
+		COALESCE(s.zslx_name, ''), s.special_name, s.min, s.average, s.max, s.min_section
+		FROM specials s LEFT JOIN schools sc ON sc.school_id = s.school_id
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY CAST(s.school_id AS INTEGER), s.special_name, s.type, s.batch, s.year DESC`, args
+}
+
+func runScoreQuery(db *sql.DB, q scoreQuery) ([]QueryRow, error) {
+	stmt, args := q.sql()
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]QueryRow, 0)
+	for rows.Next() {
+		var r QueryRow
+		var minScore, average, maxScore sql.NullFloat64
+		var minSection sql.NullInt64
+		if err := rows.Scan(&r.Year, &r.SchoolID, &r.SchoolName, &r.ProvinceID, &r.Type, &r.Batch,
+			&r.ZslxName, &r.SpecialName, &minScore, &average, &maxScore, &minSection); err != nil {
+			return nil, err
+		}
+		r.Min = NullDecimal{Float: minScore.Float64, Valid: minScore.Valid}
+		r.Average = NullDecimal{Float: average.Float64, Valid: average.Valid}
+		r.Max = NullDecimal{Float: maxScore.Float64, Valid: maxScore.Valid}
+		r.MinSection = NullInt{Int: minSection.Int64, Valid: minSection.Valid}
+		// schools without a profile are named by the school list
+		r.SchoolName = firstNonEmpty(r.SchoolName, schoolIDNameMap[r.SchoolID])
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+func writeQueryTable(w io.Writer, rows []QueryRow) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "年份\t学校ID\t学校\t省份\t科类\t批次\t招生类型\t专业\t最低分\t平均分\t最高分\t最低位次")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.Year, r.SchoolID, r.SchoolName,
+			provinceDict.name(r.ProvinceID).ZH, typeDict.name(r.Type).ZH, batchDict.name(r.Batch).ZH,
+			r.ZslxName, r.SpecialName, r.Min, r.Average, r.Max, r.MinSection)
+	}
+	return tw.Flush()
+}
+
+// queryCommand looks up scores and ranks in a snapshot through its sqlite index.
+func queryCommand(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	dir := fs.String("dir", ".", "crawl output directory")
+	index := fs.String("index", "", "sqlite index, default <dir>/"+sqliteFile)
+	reindex := fs.Bool("reindex", false, "build the index again even if it is up to date")
+	province := fs.String("province", "", "province id or name, empty means every province")
+	school := fs.String("school", "", "school id or part of its name")
+	major := fs.String("major", "", "special id or part of its name")
+	years := fs.String("years", "", "year or year range, e.g. 2023 or 2021-2024, empty means every year")
+	typ := fs.String("type", "", "type id or name, e.g. 1 or 理科")
+	batch := fs.String("batch", "", "batch id or name, e.g. 7 or 本科一批")
+	zslx := fs.String("zslx", "", "zslx_name, e.g. 普通类")
+	format := fs.String("format", formatTable, "output format: table or json")
+	must(fs.Parse(args))
+	// logs go to stderr, so the result can be piped
+	if lgr, _, err := logger.InitLogger(zapcore.InfoLevel, true, "stderr"); err == nil {
+		log = lgr
+	}
+	if *format != formatTable && *format != formatJSON {
+		return fmt.Errorf("unknown query format %q", *format)
+	}
+	from, to, err := parseYearRange(*years)
+	if err != nil {
+		return err
+	}
+	if *index == "" {
+		*index = path.Join(*dir, sqliteFile)
+	}
+	db, err := openQueryIndex(*dir, *index, *reindex)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	loadSchoolNames(path.Join(*dir, schoolListFile))
+
+	q := scoreQuery{
+		Province: provinceDict.code(*province),
+		Major:    *major,
+		From:     from,
+		To:       to,
+		Type:     typeDict.code(*typ),
+		Batch:    batchDict.code(*batch),
+		Zslx:     *zslx,
+	}
+	if *school != "" {
+		if q.Schools, err = resolveSchools(db, *dir, *school); err != nil {
+			return err
+		}
+	}
+	rows, err := runScoreQuery(db, q)
+	if err != nil {
+		return err
+	}
+	if *format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	}
+	return writeQueryTable(os.Stdout, rows)
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bzssm/goclub/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// recommendation categories
+const (
+	categoryReach  = "reach"  // 冲
+	categoryMatch  = "match"  // 稳
+	categorySafety = "safety" // 保
+)
+
+// confidence levels
+const (
+	confidenceHigh   = "high"
+	confidenceMedium = "medium"
+	confidenceLow    = "low"
+)
+
+var categoryNames = map[string]string{categoryReach: "冲", categoryMatch: "稳", categorySafety: "保"}
+var confidenceNames = map[string]string{confidenceHigh: "高", confidenceMedium: "中", confidenceLow: "低"}
+
+// Recommendation is the result of recommend for one student.
+type Recommendation struct {
+	ProvinceID string
+	Type       string
+	Batch      string `json:",omitempty"`
+	Subjects   []string
+	Rank       int64
+	LatestYear int // options have to be offered in it
+	Reach      []RecommendOption
+	Match      []RecommendOption
+	Safety     []RecommendOption
+}
+
+// RecommendOption is a special of a school with its rank history, newest year first.
+type RecommendOption struct {
+	SchoolID     string
+	SchoolName   string
+	SpecialName  string
+	ZslxName     string
+	Batch        string
+	Category     string
+	ExpectedRank int64   // recency weighted lowest admitted rank
+	Margin       float64 // (ExpectedRank - Rank) / ExpectedRank, negative means above the student
+	Confidence   string
+	Reasons      []string `json:",omitempty"` // why confidence is not high
+	History      []RankPoint
+}
+
+// RankPoint is the admission of an option in one year.
+type RankPoint struct {
+	Year       int
+	Min        NullDecimal
+	MinSection NullInt
+}
+
+// recommendRequest holds what recommend is asked for.
+type recommendRequest struct {
+	Province string
+	Type     string
+	Batch    string // empty means every batch
+	Subjects []string
+	Rank     int64
+	From, To int
+	Zslx     string
+	// margins as a fraction of the expected rank
+	ReachMargin  float64 // reach down to a cutoff rank this much better than the student
+	SafetyMargin float64 // safety from a cutoff rank this much worse than the student
+}
+
+// optionHistory collects the years of an option while walking special_detail/.
+type optionHistory struct {
+	option    RecommendOption
+	years     map[int]RankPoint
+	uncertain bool // subject requirement not understood
+	partial   bool // some year was taken from a partial record
+}
+
+// optionKey identifies an option across years. special_group is left out, its codes
+// change from year to year.
+func optionKey(school, batch, zslx, name string) string {
+	return strings.Join([]string{school, batch, zslx, name}, "|")
+}
+
+// typeOfSubjects derives the type of new gaokao provinces from the first subject.
+func typeOfSubjects(subjects []string) string {
+	if len(subjects) == 0 {
+		return ""
+	}
+	switch subjects[0] {
+	case subjectPhysics:
+		return "2073"
+	case subjectHistory:
+		return "2074"
+	}
+	return ""
+}
+
+// loadOptionHistories reads the rank history of every option the student may apply for.
+func loadOptionHistories(dir string, req recommendRequest) (map[string]*optionHistory, error) {
+	res := make(map[string]*optionHistory)
+	onlyProvince := func(key string) bool { return provinceOfKey(key) == req.Province }
+	err := walkSpecialDetail(path.Join(dir, specialDetailDir), onlyProvince, func(file string, sp SchoolProv) error {
+		for _, ytb := range sp.YTBSpecials {
+			year, err := strconv.Atoi(ytb.Year)
+			if err != nil || year < req.From || year > req.To || ytb.Typ != req.Type {
+				continue
+			}
+			if req.Batch != "" && ytb.Batch != req.Batch {
+				continue
+			}
+			for _, s := range ytb.Special {
+				if req.Zslx != "" && s.ZslxName != req.Zslx {
+					continue
+				}
+				certain := true
+				if len(req.Subjects) != 0 {
+					var eligible bool
+					if eligible, certain = s.Special.EligibleFor(req.Subjects); !eligible {
+						continue
+					}
+				}
+				schoolID := firstNonEmpty(s.SchoolID, sp.SchoolID)
+				key := optionKey(schoolID, ytb.Batch, s.ZslxName, s.Spname)
+				h, ok := res[key]
+				if !ok {
+					h = &optionHistory{
+						option: RecommendOption{
+							SchoolID:    schoolID,
+							SchoolName:  schoolIDNameMap[schoolID],
+							SpecialName: s.Spname,
+							ZslxName:    s.ZslxName,
+							Batch:       ytb.Batch,
+						},
+						years: make(map[int]RankPoint),
+					}
+					res[key] = h
+				}
+				h.uncertain = h.uncertain || !certain
+				h.partial = h.partial || (ytb.Provenance != nil && ytb.Provenance.Partial)
+				// several rows of a year, e.g. per special group, keep the lowest rank
+				p, ok := h.years[year]
+				if !ok || s.MinSection.Valid && (!p.MinSection.Valid || s.MinSection.Int > p.MinSection.Int) {
+					h.years[year] = RankPoint{Year: year, Min: s.Min, MinSection: s.MinSection}
+				}
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// expectedRank weights the ranks of recent years higher, points are newest first.
+func expectedRank(points []RankPoint) (rank int64, ranks []float64) {
+	var sum, weights float64
+	for i, p := range points {
+		if !p.MinSection.Valid {
+			continue
+		}
+		w := float64(len(points) - i)
+		sum += w * float64(p.MinSection.Int)
+		weights += w
+		ranks = append(ranks, float64(p.MinSection.Int))
+	}
+	if weights == 0 {
+		return 0, nil
+	}
+	return int64(math.Round(sum / weights)), ranks
+}
+
+// variation is the coefficient of variation of ranks.
+func variation(ranks []float64) float64 {
+	if len(ranks) < 2 {
+		return 0
+	}
+	var mean float64
+	for _, r := range ranks {
+		mean += r
+	}
+	mean /= float64(len(ranks))
+	var sq float64
+	for _, r := range ranks {
+		sq += (r - mean) * (r - mean)
+	}
+	return math.Sqrt(sq/float64(len(ranks))) / mean
+}
+
+// classify rates an option for the student rank, it returns false when the option is
+// out of reach or lacks a rank in the latest year.
+func (h *optionHistory) classify(req recommendRequest, latest int) (RecommendOption, bool) {
+	o := h.option
+	if p, ok := h.years[latest]; !ok || !p.MinSection.Valid {
+		return o, false
+	}
+	o.History = make([]RankPoint, 0, len(h.years))
+	for _, p := range h.years {
+		o.History = append(o.History, p)
+	}
+	sort.Slice(o.History, func(i, j int) bool { return o.History[i].Year > o.History[j].Year })
+	rank, ranks := expectedRank(o.History)
+	o.ExpectedRank = rank
+	o.Margin = float64(rank-req.Rank) / float64(rank)
+	switch {
+	case o.Margin < -req.ReachMargin:
+		return o, false
+	case o.Margin < 0:
+		o.Category = categoryReach
+	case o.Margin < req.SafetyMargin:
+		o.Category = categoryMatch
+	default:
+		o.Category = categorySafety
+	}
+
+	// every reason costs confidence, a single year or a volatile history costs two levels
+	o.Reasons = make([]string, 0)
+	penalty := 0
+	switch len(ranks) {
+	case 1:
+		o.Reasons = append(o.Reasons, "1 year of rank history")
+		penalty += 2
+	case 2:
+		o.Reasons = append(o.Reasons, "2 years of rank history")
+		penalty++
+	}
+	if cv := variation(ranks); cv > 0.15 {
+		o.Reasons = append(o.Reasons, fmt.Sprintf("ranks vary by %.0f%%", cv*100))
+		penalty++
+		if cv > 0.3 {
+			penalty++
+		}
+	}
+	if h.uncertain {
+		o.Reasons = append(o.Reasons, "subject requirement not understood")
+		penalty++
+	}
+	if h.partial {
+		o.Reasons = append(o.Reasons, "taken from a partial record")
+		penalty++
+	}
+	switch {
+	case penalty == 0:
+		o.Confidence = confidenceHigh
+	case penalty == 1:
+		o.Confidence = confidenceMedium
+	default:
+		o.Confidence = confidenceLow
+	}
+	return o, true
+}
+
+// recommend classifies the options of a snapshot for req. every list is ordered by
+// expected rank, better schools first, and cut at limit when limit > 0.
+func recommend(dir string, req recommendRequest, limit int) (*Recommendation, error) {
+	histories, err := loadOptionHistories(dir, req)
+	if err != nil {
+		return nil, err
+	}
+	res := &Recommendation{
+		ProvinceID: req.Province,
+		Type:       req.Type,
+		Batch:      req.Batch,
+		Subjects:   req.Subjects,
+		Rank:       req.Rank,
+		Reach:      make([]RecommendOption, 0),
+		Match:      make([]RecommendOption, 0),
+		Safety:     make([]RecommendOption, 0),
+	}
+	for _, h := range histories {
+		for year := range h.years {
+			if year > res.LatestYear {
+				res.LatestYear = year
+			}
+		}
+	}
+	for _, h := range histories {
+		o, ok := h.classify(req, res.LatestYear)
+		if !ok {
+			continue
+		}
+		switch o.Category {
+		case categoryReach:
+			res.Reach = append(res.Reach, o)
+		case categoryMatch:
+			res.Match = append(res.Match, o)
+		case categorySafety:
+			res.Safety = append(res.Safety, o)
+		}
+	}
+	for _, list := range []*[]RecommendOption{&res.Reach, &res.Match, &res.Safety} {
+		options := *list
+		sort.Slice(options, func(i, j int) bool {
+			x, y := options[i], options[j]
+			if x.ExpectedRank != y.ExpectedRank {
+				return x.ExpectedRank < y.ExpectedRank
+			}
+			if x.SchoolID != y.SchoolID {
+				return lessID(x.SchoolID, y.SchoolID)
+			}
+			return x.SpecialName < y.SpecialName
+		})
+		// safety options closest to the student are the useful ones
+		if list == &res.Safety {
+			sort.SliceStable(options, func(i, j int) bool { return options[i].Margin < options[j].Margin })
+		}
+		if limit > 0 && len(options) > limit {
+			*list = options[:limit]
+		}
+	}
+	return res, nil
+}
+
+func writeRecommendTable(w io.Writer, r *Recommendation) error {
+	title := []string{provinceDict.name(r.ProvinceID).ZH, typeDict.name(r.Type).ZH}
+	if r.Batch != "" {
+		title = append(title, batchDict.name(r.Batch).ZH)
+	}
+	if len(r.Subjects) != 0 {
+		title = append(title, strings.Join(r.Subjects, "、"))
+	}
+	fmt.Fprintf(w, "%v 位次 %v, 历年至 %v\n", strings.Join(title, " "), r.Rank, r.LatestYear)
+	for _, section := range []struct {
+		category string
+		options  []RecommendOption
+	}{{categoryReach, r.Reach}, {categoryMatch, r.Match}, {categorySafety, r.Safety}} {
+		fmt.Fprintf(w, "\n%v %v (%v)\n", categoryNames[section.category], section.category, len(section.options))
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "学校ID\t学校\t批次\t招生类型\t专业\t预估位次\t差距\t置信度\t历年最低位次")
+		for _, o := range section.options {
+			history := make([]string, 0, len(o.History))
+			for _, p := range o.History {
+				history = append(history, fmt.Sprintf("%v:%v", p.Year, p.MinSection))
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%+.0f%%\t%v\t%v\n", o.SchoolID, o.SchoolName,
+				batchDict.name(o.Batch).ZH, o.ZslxName, o.SpecialName, o.ExpectedRank, o.Margin*100,
+				confidenceNames[o.Confidence], strings.Join(history, " "))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recommendCommand classifies the specials a student may apply for into reach, match
+// and safety by comparing the student rank with the lowest admitted ranks of past years.
+func recommendCommand(args []string) error {
+	fs := flag.NewFlagSet("recommend", flag.ExitOnError)
+	dir := fs.String("dir", ".", "crawl output directory")
+	province := fs.String("province", "", "province id or name, required")
+	typ := fs.String("type", "", "type id or name, e.g. 1 or 理科, derived from -subjects when empty")
+	subjects := fs.String("subjects", "", "selected subjects of new gaokao provinces, e.g. 物理,化学,生物")
+	batch := fs.String("batch", "", "batch id or name, e.g. 7 or 本科一批, empty means every batch")
+	rank := fs.Int64("rank", 0, "provincial rank of the student")
+	years := fs.String("years", "", "year range of the history, e.g. 2021-2024, empty means every year")
+	zslx := fs.String("zslx", "", "zslx_name, e.g. 普通类, empty means every kind")
+	reach := fs.Float64("reach", 0.15, "reach options up to a cutoff rank this fraction better than the student")
+	safety := fs.Float64("safety", 0.15, "safety options from a cutoff rank this fraction worse than the student")
+	limit := fs.Int("limit", 30, "options per category, 0 means all")
+	format := fs.String("format", formatTable, "output format: table or json")
+	must(fs.Parse(args))
+	// logs go to stderr, so the result can be piped
+	if lgr, _, err := logger.InitLogger(zapcore.InfoLevel, true, "stderr"); err == nil {
+		log = lgr
+	}
+	if *format != formatTable && *format != formatJSON {
+		return fmt.Errorf("unknown recommend format %q", *format)
+	}
+	if *rank <= 0 || *province == "" {
+		return fmt.Errorf("usage: recommend -province <province> -rank <rank> [flags]")
+	}
+	from, to, err := parseYearRange(*years)
+	if err != nil {
+		return err
+	}
+	req := recommendRequest{
+		Province:     provinceDict.code(*province),
+		Type:         typeDict.code(*typ),
+		Batch:        batchDict.code(*batch),
+		Subjects:     parseSubjects(*subjects),
+		Rank:         *rank,
+		From:         from,
+		To:           to,
+		Zslx:         *zslx,
+		ReachMargin:  *reach,
+		SafetyMargin: *safety,
+	}
+	if req.Type == "" {
+		req.Type = typeOfSubjects(req.Subjects)
+	}
+	if req.Type == "" {
+		return fmt.Errorf("-type is required unless -subjects starts with %v or %v", subjectPhysics, subjectHistory)
+	}
+	loadSchoolNames(path.Join(*dir, schoolListFile))
+	res, err := recommend(*dir, req, *limit)
+	if err != nil {
+		return err
+	}
+	log.Infow("recommended",
+		zap.Int("reach", len(res.Reach)),
+		zap.Int("match", len(res.Match)),
+		zap.Int("safety", len(res.Safety)),
+		zap.Int("latest year", res.LatestYear))
+	if *format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(res)
+	}
+	return writeRecommendTable(os.Stdout, res)
+}
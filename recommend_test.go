@@ -0,0 +1,98 @@
+package main
+
+import "testing"
+
+// testHistory is an option with min ranks of 2023 and the years before it, 0 is missing.
+func testHistory(ranks ...int64) *optionHistory {
+	h := &optionHistory{option: RecommendOption{SchoolID: "31", SpecialName: "数学"}, years: make(map[int]RankPoint)}
+	for i, r := range ranks {
+		h.years[2023-i] = RankPoint{Year: 2023 - i, MinSection: NullInt{r, r != 0}}
+	}
+	return h
+}
+
+func TestExpectedRank(t *testing.T) {
+	point := func(year int, rank int64) RankPoint {
+		return RankPoint{Year: year, MinSection: NullInt{rank, rank != 0}}
+	}
+	for _, c := range []struct {
+		points []RankPoint
+		want   int64
+	}{
+		// weights 3, 2, 1 from the newest year
+		{[]RankPoint{point(2023, 1000), point(2022, 2000), point(2021, 3000)}, 1667},
+		{[]RankPoint{point(2023, 3000), point(2022, 2000), point(2021, 1000)}, 2333},
+		// a missing year keeps the weights of the others
+		{[]RankPoint{point(2023, 1000), point(2022, 0), point(2021, 3000)}, 1500},
+		{[]RankPoint{point(2023, 1200)}, 1200},
+		{[]RankPoint{point(2023, 0)}, 0},
+		{nil, 0},
+	} {
+		if got, _ := expectedRank(c.points); got != c.want {
+			t.Errorf("%v: expected rank %v, want %v", c.points, got, c.want)
+		}
+	}
+}
+
+func TestClassifyThresholds(t *testing.T) {
+	req := recommendRequest{Rank: 1000, ReachMargin: 0.15, SafetyMargin: 0.15}
+	for _, c := range []struct {
+		cutoff int64
+		want   string // empty means left out
+	}{
+		{850, ""},              // 18% above the student
+		{870, categoryReach},   // 15% above
+		{900, categoryReach},   // 11% above
+		{1000, categoryMatch},  // the student rank
+		{1150, categoryMatch},  // 13% below
+		{1177, categorySafety}, // 15% below
+		{2000, categorySafety},
+	} {
+		got := ""
+		if o, ok := testHistory(c.cutoff, c.cutoff, c.cutoff).classify(req, 2023); ok {
+			got = o.Category
+		}
+		if got != c.want {
+			t.Errorf("cutoff %v: %q, want %q", c.cutoff, got, c.want)
+		}
+	}
+
+	// the latest year decides whether the special is still offered
+	if _, ok := testHistory(0, 1000, 1000).classify(req, 2023); ok {
+		t.Error("option without a rank in the latest year classified")
+	}
+	if _, ok := testHistory(1000).classify(req, 2024); ok {
+		t.Error("option not offered in the latest year classified")
+	}
+}
+
+func TestClassifyConfidence(t *testing.T) {
+	req := recommendRequest{Rank: 1000, ReachMargin: 0.15, SafetyMargin: 0.15}
+	uncertain := testHistory(1000, 1000, 1000)
+	uncertain.uncertain = true
+	partial := testHistory(1000, 1000, 1000)
+	partial.uncertain, partial.partial = true, true
+	for _, c := range []struct {
+		name    string
+		h       *optionHistory
+		want    string
+		reasons int
+	}{
+		{"three stable years", testHistory(1000, 1000, 1000), confidenceHigh, 0},
+		{"two years", testHistory(1000, 1000), confidenceMedium, 1},
+		{"one year", testHistory(1000), confidenceLow, 1},
+		{"ranks vary by 17%", testHistory(1000, 1000, 1400), confidenceMedium, 1},
+		{"ranks vary by 53%", testHistory(500, 1000, 2000), confidenceLow, 1},
+		{"requirement not understood", uncertain, confidenceMedium, 1},
+		{"not understood and partial", partial, confidenceLow, 2},
+	} {
+		o, ok := c.h.classify(req, 2023)
+		if !ok {
+			t.Errorf("%v: left out", c.name)
+			continue
+		}
+		if o.Confidence != c.want || len(o.Reasons) != c.reasons {
+			t.Errorf("%v: confidence %v reasons %v, want %v with %v reasons", c.name, o.Confidence, o.Reasons, c.want, c.reasons)
+		}
+	}
+}
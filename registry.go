@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	schoolRegistryFile       = "school_registry.json"
+	schoolRegistryReportFile = "school_registry_report.json"
+	schoolNumRawFile         = "RAW_school_num.json"
+)
+
+// registry sources
+const (
+	sourceNameList  = "name.json"
+	sourceSchoolNum = "schoolnum.json"
+	sourceInfo      = "info.json"
+)
+
+// schoolNum is the response of schoolnum.json, the school list main_deprecated.go used.
+type schoolNum struct {
+	Data map[string]struct {
+		Name string `json:"school_name"`
+	} `json:"data"`
+}
+
+var schoolNumEndpoint = &endpoint{
+	Stage:       stageSchoolNum,
+	URLFormat:   "https://static-gkcx.gaokao.cn/www/2.0/json/live/v2/schoolnum.json",
+	CheckStatus: true,
+	Response:    schoolNum{},
+}
+
+// SchoolRegistry is the merged school list, written to school_registry.json. it drives
+// every later stage and is the previous snapshot of the next run.
+type SchoolRegistry struct {
+	UpdatedAt time.Time
+	Sources   []string // sources fetched by the run
+	Schools   []RegistrySchool
+}
+
+type RegistrySchool struct {
+	SchoolID string
+	Name     string
+	Sources  []string          // sources listing the school
+	Names    map[string]string `json:",omitempty"` // source -> name, only when sources disagree
+}
+
+// SchoolRegistryReport is written to school_registry_report.json.
+type SchoolRegistryReport struct {
+	Schools         int
+	OnlyInNameList  []RegistrySchool
+	OnlyInSchoolNum []RegistrySchool
+	Renamed         []RenamedSchool
+	Vanished        []RegistrySchool // in the previous snapshot, in no source now
+}
+
+type RenamedSchool struct {
+	SchoolID     string
+	Name         string
+	PreviousName string `json:",omitempty"` // name in the previous snapshot
+	OldName      string `json:",omitempty"` // old_name of school info
+	Reason       string
+}
+
+// rename reasons
+const (
+	renamedSinceSnapshot = "changed since previous snapshot"
+	renamedSources       = "sources disagree"
+)
+
+// fetchSchoolSource fetches a school list source and keeps its raw response in rawFile.
+func fetchSchoolSource(e *endpoint, rawFile string, v interface{}) error {
+	crawlBudget.setStage(e.Stage)
+	url := e.url(nil, 1)
+	content, err := request(url, e.CheckStatus)
+	if err != nil {
+		return err
+	}
+	schemaDrift.observe(e.Stage, url, content)
+	if err := os.WriteFile(rawFile, content, 0666); err != nil {
+		log.Fatalw("write raw response failed", zap.Error(err), zap.String("file", rawFile))
+	}
+	return json.Unmarshal(content, v)
+}
+
+// schoolRegistryStage pulls name.json and schoolnum.json, merges them by id, reports the
+// differences to the previous snapshot and writes the registry. a missing source is
+// tolerated as long as the other one arrived.
+func schoolRegistryStage() (*SchoolRegistry, error) {
+	var nameList school
+	nameErr := fetchSchoolSource(schoolListEndpoint, "RAW_"+schoolListFile, &nameList)
+	if errors.Is(nameErr, errBudgetExhausted) {
+		return nil, nameErr
+	}
+	var num schoolNum
+	numErr := fetchSchoolSource(schoolNumEndpoint, schoolNumRawFile, &num)
+	if errors.Is(numErr, errBudgetExhausted) {
+		return nil, numErr
+	}
+	if nameErr != nil && numErr != nil {
+		return nil, nameErr
+	}
+	sources := make(map[string]map[string]string) // source -> id -> name
+	if nameErr != nil {
+		log.Warnw("school list source failed", zap.Error(nameErr), zap.String("source", sourceNameList))
+	} else {
+		sources[sourceNameList] = make(map[string]string, len(nameList.Data))
+		for _, sc := range nameList.Data {
+			sources[sourceNameList][sc.SchoolID] = strings.TrimSpace(sc.Name)
+		}
+	}
+	if numErr != nil {
+		log.Warnw("school list source failed", zap.Error(numErr), zap.String("source", sourceSchoolNum))
+	} else {
+		sources[sourceSchoolNum] = make(map[string]string, len(num.Data))
+		for id, sc := range num.Data {
+			sources[sourceSchoolNum][id] = strings.TrimSpace(sc.Name)
+		}
+	}
+
+	previous := loadSchoolRegistry(schoolRegistryFile)
+	registry, registryReport := mergeSchoolSources(sources, previous, loadOldNames(schoolInfoDir))
+	// like main_deprecated.go, info.json names schools no list names
+	crawlBudget.setStage(stageSchoolList)
+	for i, sc := range registry.Schools {
+		if sc.Name != "" {
+			continue
+		}
+		name, err := fetchSchoolName(sc.SchoolID)
+		if errors.Is(err, errBudgetExhausted) {
+			return nil, err
+		}
+		if name != "" {
+			registry.Schools[i].Name = name
+			registry.Schools[i].Sources = append(registry.Schools[i].Sources, sourceInfo)
+		}
+	}
+	for i, sc := range registryReport.OnlyInSchoolNum {
+		if sc.Name == "" {
+			registryReport.OnlyInSchoolNum[i].Name = registry.name(sc.SchoolID)
+		}
+	}
+
+	if err := writeJSON(schoolRegistryFile, registry); err != nil {
+		return nil, err
+	}
+	if err := writeJSON(schoolRegistryReportFile, registryReport); err != nil {
+		return nil, err
+	}
+	log.Infow("school registry merged",
+		zap.Int("schools", registryReport.Schools),
+		zap.Int("only in name.json", len(registryReport.OnlyInNameList)),
+		zap.Int("only in schoolnum.json", len(registryReport.OnlyInSchoolNum)),
+		zap.Int("renamed", len(registryReport.Renamed)),
+		zap.Int("vanished", len(registryReport.Vanished)))
+	return registry, nil
+}
+
+// mergeSchoolSources merges source -> id -> name by id. name.json wins a name conflict
+// unless its name is the old_name of the school, then it is stale.
+func mergeSchoolSources(sources map[string]map[string]string, previous *SchoolRegistry, oldNames map[string]string) (*SchoolRegistry, *SchoolRegistryReport) {
+	registry := &SchoolRegistry{UpdatedAt: time.Now(), Sources: make([]string, 0), Schools: make([]RegistrySchool, 0)}
+	registryReport := &SchoolRegistryReport{
+		OnlyInNameList:  make([]RegistrySchool, 0),
+		OnlyInSchoolNum: make([]RegistrySchool, 0),
+		Renamed:         make([]RenamedSchool, 0),
+		Vanished:        make([]RegistrySchool, 0),
+	}
+	ids := make(map[string]bool)
+	for _, source := range []string{sourceNameList, sourceSchoolNum} {
+		if _, ok := sources[source]; !ok {
+			continue
+		}
+		registry.Sources = append(registry.Sources, source)
+		for id := range sources[source] {
+			ids[id] = true
+		}
+	}
+	previousNames := make(map[string]string)
+	if previous != nil {
+		for _, sc := range previous.Schools {
+			previousNames[sc.SchoolID] = sc.Name
+		}
+	}
+
+	for id := range ids {
+		sc := RegistrySchool{SchoolID: id, Sources: make([]string, 0, 2)}
+		names := make(map[string]string)
+		for _, source := range registry.Sources {
+			if name, ok := sources[source][id]; ok {
+				sc.Sources = append(sc.Sources, source)
+				if name != "" {
+					names[source] = name
+				}
+			}
+		}
+		sc.Name = firstNonEmpty(names[sourceNameList], names[sourceSchoolNum])
+		if len(names) == 2 && names[sourceNameList] != names[sourceSchoolNum] {
+			sc.Names = names
+			if names[sourceNameList] == oldNames[id] {
+				sc.Name = names[sourceSchoolNum]
+			}
+			registryReport.Renamed = append(registryReport.Renamed, RenamedSchool{
+				SchoolID:     id,
+				Name:         sc.Name,
+				PreviousName: previousNames[id],
+				OldName:      oldNames[id],
+				Reason:       renamedSources,
+			})
+		} else if prev, ok := previousNames[id]; ok && prev != "" && sc.Name != "" && prev != sc.Name {
+			registryReport.Renamed = append(registryReport.Renamed, RenamedSchool{
+				SchoolID:     id,
+				Name:         sc.Name,
+				PreviousName: prev,
+				OldName:      oldNames[id],
+				Reason:       renamedSinceSnapshot,
+			})
+		}
+		// only worth reporting when both sources arrived
+		if len(registry.Sources) == 2 && len(sc.Sources) == 1 {
+			if sc.Sources[0] == sourceNameList {
+				registryReport.OnlyInNameList = append(registryReport.OnlyInNameList, sc)
+			} else {
+				registryReport.OnlyInSchoolNum = append(registryReport.OnlyInSchoolNum, sc)
+			}
+		}
+		registry.Schools = append(registry.Schools, sc)
+	}
+	if previous != nil {
+		for _, sc := range previous.Schools {
+			if !ids[sc.SchoolID] {
+				registryReport.Vanished = append(registryReport.Vanished, sc)
+			}
+		}
+	}
+
+	byID := func(s []RegistrySchool) func(i, j int) bool {
+		return func(i, j int) bool { return lessID(s[i].SchoolID, s[j].SchoolID) }
+	}
+	sort.Slice(registry.Schools, byID(registry.Schools))
+	sort.Slice(registryReport.OnlyInNameList, byID(registryReport.OnlyInNameList))
+	sort.Slice(registryReport.OnlyInSchoolNum, byID(registryReport.OnlyInSchoolNum))
+	sort.Slice(registryReport.Vanished, byID(registryReport.Vanished))
+	sort.Slice(registryReport.Renamed, func(i, j int) bool {
+		return lessID(registryReport.Renamed[i].SchoolID, registryReport.Renamed[j].SchoolID)
+	})
+	registryReport.Schools = len(registry.Schools)
+	return registry, registryReport
+}
+
+// fetchSchoolName reads the name of a school from info.json, "" when it has none.
+func fetchSchoolName(id string) (string, error) {
+	content, err := request(schoolInfoEndpoint.url([]string{id}, 1), schoolInfoEndpoint.CheckStatus)
+	if err != nil {
+		return "", err
+	}
+	var schoolInfoJSON info
+	if err := json.Unmarshal(content, &schoolInfoJSON); err != nil {
+		log.Errorw("unmarshal school info failed", zap.Error(err), zap.String("id", id))
+		return "", nil
+	}
+	return strings.TrimSpace(schoolInfoJSON.Data.Name), nil
+}
+
+// loadSchoolRegistry reads the previous snapshot, nil on the first run.
+func loadSchoolRegistry(file string) *SchoolRegistry {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var registry SchoolRegistry
+	if err := json.Unmarshal(content, &registry); err != nil {
+		log.Warnw("previous school registry ignored", zap.Error(err), zap.String("file", file))
+		return nil
+	}
+	return &registry
+}
+
+// loadOldNames reads id -> old_name from the school profiles of an earlier run.
+func loadOldNames(dir string) map[string]string {
+	res := make(map[string]string)
+	profiles, err := loadSchoolProfiles(dir)
+	if err != nil {
+		log.Warnw("old school names ignored", zap.Error(err), zap.String("dir", dir))
+		return res
+	}
+	for id, profile := range profiles {
+		if profile.OldName != "" {
+			res[id] = profile.OldName
+		}
+	}
+	return res
+}
+
+// schoolData is the registry in the school list layout of name.json.
+func (r *SchoolRegistry) schoolData() []schoolData {
+	res := make([]schoolData, 0, len(r.Schools))
+	for _, sc := range r.Schools {
+		res = append(res, schoolData{SchoolID: sc.SchoolID, Name: sc.Name})
+	}
+	return res
+}
+
+func (r *SchoolRegistry) name(id string) string {
+	for _, sc := range r.Schools {
+		if sc.SchoolID == id {
+			return sc.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const runReportFile = "run_report.json"
+
+// runReport summarizes one crawl run: what it spent, what failed and what is left to do.
+type runReport struct {
+	StartedAt       time.Time
+	FinishedAt      time.Time
+	Complete        bool
+	BudgetExhausted string `json:",omitempty"`
+	Requests        int64
+	Bytes           int64
+	StageRequests   map[string]int64
+	Failed          map[string]int64
+	BreakerTrips    int64
+	Requeued        int64 // requests sent again after an upstream outage
+	SchemaDrift     int   // distinct drift findings, see schema_drift.json
+	Remaining       map[string]*stageRemaining
+
+	mu    sync.Mutex
+	items map[string][]string // stage -> all work keys of the stage
+}
+
+type stageRemaining struct {
+	Total   int
+	Done    int
+	Pending []string
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		StartedAt: time.Now(),
+		Failed:    make(map[string]int64),
+		Remaining: make(map[string]*stageRemaining),
+		items:     make(map[string][]string),
+	}
+}
+
+// track registers the work keys of a stage, used to work out the pending items at the end.
+func (r *runReport) track(stage string, keys []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items[stage] = keys
+}
+
+// finish fills spending and remaining work from the budget and checkpoint, then writes the report.
+func (r *runReport) finish(b *budget, cp *checkpoint) error {
+	r.FinishedAt = time.Now()
+	b.mu.Lock()
+	r.BudgetExhausted = b.reason
+	r.Requests = b.requests
+	r.Bytes = b.bytes
+	r.StageRequests = b.stageRequests
+	b.mu.Unlock()
+	r.mu.Lock()
+	for stage, keys := range r.items {
+		remaining := &stageRemaining{Total: len(keys), Pending: make([]string, 0)}
+		for _, key := range keys {
+			if cp.isDone(stage, key) {
+				remaining.Done++
+			} else {
+				remaining.Pending = append(remaining.Pending, key)
+			}
+		}
+		sort.Strings(remaining.Pending)
+		r.Remaining[stage] = remaining
+	}
+	r.mu.Unlock()
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(runReportFile, content, 0666)
+}
@@ -1,6 +1,10 @@
 package main
 
-import "fmt"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 type school struct {
 	Data []schoolData `json:"data"`
@@ -64,23 +68,16 @@ type ptb struct {
 		Data []struct {
 			Year     int `json:"year"`
 			Province []struct {
-				Pid   int   `json:"pid"`
-				Type  []int `json:"type"`
-				Batch []int `json:"batch"`
+				Pid        int             `json:"pid"`
+				Type       []int           `json:"type"`
+				Batch      []int           `json:"batch"`
+				BatchGroup json.RawMessage `json:"batch_group"`
+				First      json.RawMessage `json:"first"` // 首选科目
 			} `json:"province"`
 		} `json:"data"`
 	} `json:"data"`
 }
 
-type detailGroup struct {
-	Key   [2]string   // [school, prov]
-	Value [][3]string // [[year, type, batch]....]
-}
-
-func (g *detailGroup) String() string {
-	return fmt.Sprintf("year: %v, school: %v, province: %v, type: %v, batch: %v", g.Value[0], g.Key[0], g.Key[1], g.Value[1], g.Value[2])
-}
-
 type SchoolSpecial struct {
 	Data struct {
 		NumFound int       `json:"numFound"`
@@ -89,10 +86,22 @@ type SchoolSpecial struct {
 }
 
 type YTBSpecial struct {
-	Year    string
-	Typ     string
-	Batch   string
-	Special []Special
+	Year      string
+	Typ       string
+	TypName   CodeName
+	Batch     string
+	BatchName CodeName
+	Special   []TypedSpecial
+	// only set by merge and import-legacy
+	Provenance *Provenance `json:",omitempty"`
+}
+
+// Provenance names the run a merged or imported record was taken from.
+type Provenance struct {
+	Run     string    // crawl output directory
+	RunAt   time.Time // when the run fetched it, zero when unknown
+	Source  string    // file, or part file and line, it was read from
+	Partial bool      `json:",omitempty"` // some specials are missing, e.g. only the first page was fetched
 }
 
 type Special struct {
@@ -131,7 +140,13 @@ type Special struct {
 }
 
 type SchoolProv struct {
-	SchoolID    string
-	ProvinceID  string
-	YTBSpecials []YTBSpecial
+	SchoolID     string
+	ProvinceID   string
+	ProvinceName CodeName
+	YTBSpecials  []YTBSpecial
+}
+
+// key identifies a special within one year/type/batch of a school and province.
+func (s Special) key() string {
+	return strings.Join([]string{s.SpecialID, s.SpeID, s.Zslx, s.SpecialGroup}, "|")
 }
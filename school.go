@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SchoolProfile is the normalized form of schoolInfo, written to school_info/.
+type SchoolProfile struct {
+	SchoolID      string
+	Name          string
+	OldName       string
+	Is985         bool
+	Is211         bool
+	IsDualClass   bool
+	DualClassName string
+	// 双一流学科
+	DualClassSubjects []DualClassSubject
+	BatchIDs          []int
+
+	Belong           string
+	CityID           string
+	CityName         string
+	CountyID         string
+	Address          string
+	Phone            string
+	Postcode         string
+	CreateDate       string
+	Level            string
+	LevelName        string
+	NatureName       string
+	SchoolNature     string
+	SchoolNatureName string
+	SchoolType       string
+	SchoolTypeName   string
+	Type             string
+	TypeName         string
+
+	NumAcademician NullInt
+	NumDoctor      NullInt
+	NumDoctor2     NullInt
+	NumLab         NullInt
+	NumLibrary     NullInt
+	NumMaster      NullInt
+	NumMaster2     NullInt
+	NumSubject     NullInt
+
+	QsRank     NullInt
+	QsWorld    NullInt
+	RuankeRank NullInt
+
+	Warnings []string `json:",omitempty"`
+}
+
+type DualClassSubject struct {
+	ID   string
+	Name string
+}
+
+// Profile normalizes the upstream school info. counts and ranks which can not be parsed
+// are kept as missing and reported in Warnings.
+func (s schoolInfo) Profile() SchoolProfile {
+	p := SchoolProfile{
+		SchoolID:          s.SchoolID,
+		Name:              s.Name,
+		OldName:           s.OldName,
+		Is985:             s.F985 == "1",
+		Is211:             s.F211 == "1",
+		IsDualClass:       s.DualClassName != "" || len(s.Dualclass) != 0,
+		DualClassName:     s.DualClassName,
+		DualClassSubjects: make([]DualClassSubject, 0, len(s.Dualclass)),
+		BatchIDs:          make([]int, 0),
+		Belong:            s.Belong,
+		CityID:            s.CityID,
+		CityName:          s.CityName,
+		CountyID:          s.CountyID,
+		Address:           s.Address,
+		Phone:             s.Phone,
+		Postcode:          s.Postcode,
+		CreateDate:        s.CreateDate,
+		Level:             s.Level,
+		LevelName:         s.LevelName,
+		NatureName:        s.NatureName,
+		SchoolNature:      s.SchoolNature,
+		SchoolNatureName:  s.SchoolNatureName,
+		SchoolType:        s.SchoolType,
+		SchoolTypeName:    s.SchoolTypeName,
+		Type:              s.Type,
+		TypeName:          s.TypeName,
+	}
+	for _, dc := range s.Dualclass {
+		p.DualClassSubjects = append(p.DualClassSubjects, DualClassSubject{ID: dc.ID, Name: dc.Class})
+	}
+	for _, batch := range strings.Split(s.SchoolBatch, ",") {
+		if batch = strings.TrimSpace(batch); batch == "" {
+			continue
+		}
+		id, err := strconv.Atoi(batch)
+		if err != nil {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("school_batch: invalid batch id %q", batch))
+			continue
+		}
+		p.BatchIDs = append(p.BatchIDs, id)
+	}
+	for _, f := range []struct {
+		name string
+		raw  string
+		dst  *NullInt
+	}{
+		{"num_academician", s.NumAcademician, &p.NumAcademician},
+		{"num_doctor", s.NumDoctor, &p.NumDoctor},
+		{"num_doctor2", s.NumDoctor2, &p.NumDoctor2},
+		{"num_lab", s.NumLab, &p.NumLab},
+		{"num_library", s.NumLibrary, &p.NumLibrary},
+		{"num_master", s.NumMaster, &p.NumMaster},
+		{"num_master2", s.NumMaster2, &p.NumMaster2},
+		{"num_subject", s.NumSubject, &p.NumSubject},
+		{"qs_rank", s.QsRank, &p.QsRank},
+		{"qs_world", s.QsWorld, &p.QsWorld},
+		{"ruanke_rank", s.RuankeRank, &p.RuankeRank},
+	} {
+		v, err := parseNullInt(f.raw)
+		if err != nil {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("%v: %v", f.name, err))
+		}
+		*f.dst = v
+	}
+	// ranks start from 1, 0 means not ranked
+	for _, rank := range []*NullInt{&p.QsRank, &p.QsWorld, &p.RuankeRank} {
+		if rank.Valid && rank.Int == 0 {
+			*rank = NullInt{}
+		}
+	}
+	return p
+}
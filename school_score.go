@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+const schoolScoreDir = "school_score"
+
+// SchoolScore is a page of schoolprovinceindex.
+type SchoolScore struct {
+	Data struct {
+		NumFound int               `json:"numFound"`
+		Item     []SchoolScoreItem `json:"item"`
+	} `json:"data"`
+}
+
+// SchoolScoreItem is one admission line of a school, a school may have several lines
+// per year, type and batch, e.g. one per 招生类型 or 专业组.
+type SchoolScoreItem struct {
+	SchoolID       looseString `json:"school_id"`
+	Year           looseString `json:"year"`
+	Type           looseString `json:"type"`
+	Batch          looseString `json:"batch"`
+	Min            looseString `json:"min"`
+	Average        looseString `json:"average"`
+	Max            looseString `json:"max"`
+	MinSection     looseString `json:"min_section"`
+	Proscore       looseString `json:"proscore"` // 省控线
+	ZslxName       looseString `json:"zslx_name"`
+	LocalBatchName looseString `json:"local_batch_name"`
+	SpecialGroup   looseString `json:"special_group"`
+	SgName         looseString `json:"sg_name"`
+	SgInfo         looseString `json:"sg_info"`
+}
+
+// SchoolScoreLine is a school admission line with parsed scores and rank.
+type SchoolScoreLine struct {
+	Year           string
+	Typ            string
+	TypName        CodeName
+	Batch          string
+	BatchName      CodeName
+	ZslxName       string
+	LocalBatchName string
+	SpecialGroup   string
+	SgName         string
+	SgInfo         string
+	Min            NullDecimal
+	Average        NullDecimal
+	Max            NullDecimal
+	MinSection     NullInt
+	Proscore       NullDecimal
+	Warnings       []string `json:",omitempty"`
+}
+
+// BatchGroup is the batch_group and first (首选科目) data of a school, province and
+// year in provincescore.json, kept as upstream sends it.
+type BatchGroup struct {
+	Year       string
+	BatchGroup json.RawMessage `json:",omitempty"`
+	First      json.RawMessage `json:",omitempty"`
+}
+
+// SchoolProvScore is written to school_score/<school>_<province>.json, parallel to special_detail/.
+type SchoolProvScore struct {
+	SchoolID     string
+	ProvinceID   string
+	ProvinceName CodeName
+	BatchGroups  []BatchGroup
+	Lines        []SchoolScoreLine
+}
+
+// Line parses the scores of item, year, type and batch come from the request.
+func (i SchoolScoreItem) Line(year, typ, batch string) SchoolScoreLine {
+	l := SchoolScoreLine{
+		Year:           year,
+		Typ:            typ,
+		TypName:        typeDict.name(typ),
+		Batch:          batch,
+		BatchName:      batchDict.name(batch),
+		ZslxName:       string(i.ZslxName),
+		LocalBatchName: string(i.LocalBatchName),
+		SpecialGroup:   string(i.SpecialGroup),
+		SgName:         string(i.SgName),
+		SgInfo:         string(i.SgInfo),
+	}
+	var err error
+	decimals := []struct {
+		name  string
+		value looseString
+		dst   *NullDecimal
+	}{{"min", i.Min, &l.Min}, {"average", i.Average, &l.Average}, {"max", i.Max, &l.Max}, {"proscore", i.Proscore, &l.Proscore}}
+	for _, d := range decimals {
+		if *d.dst, err = parseNullDecimal(string(d.value)); err != nil {
+			l.Warnings = append(l.Warnings, fmt.Sprintf("%v: %v", d.name, err))
+		}
+	}
+	if l.MinSection, err = parseNullInt(string(i.MinSection)); err != nil {
+		l.Warnings = append(l.Warnings, fmt.Sprintf("min_section: %v", err))
+	}
+	return l
+}
+
+// loadBatchGroups reads batch_group and first of a school in a province from the raw
+// provincescore.json stage 3 kept in school_ptb/.
+func loadBatchGroups(school, prov string) []BatchGroup {
+	files, _ := filepath.Glob(path.Join(schoolPTBRawDir, school+"_*.json"))
+	if len(files) == 0 {
+		return nil
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		log.Errorw("read school ptb raw failed", zap.Error(err), zap.String("file", files[0]))
+		return nil
+	}
+	var schoolPTB ptb
+	if err := json.Unmarshal(content, &schoolPTB); err != nil {
+		log.Errorw("unmarshal school ptb failed", zap.Error(err), zap.String("file", files[0]))
+		return nil
+	}
+	res := make([]BatchGroup, 0)
+	for _, yearData := range schoolPTB.Data.Data {
+		for _, provinceData := range yearData.Province {
+			if strconv.Itoa(provinceData.Pid) != prov {
+				continue
+			}
+			if isEmptyJSON(provinceData.BatchGroup) && isEmptyJSON(provinceData.First) {
+				continue
+			}
+			res = append(res, BatchGroup{
+				Year:       strconv.Itoa(yearData.Year),
+				BatchGroup: provinceData.BatchGroup,
+				First:      provinceData.First,
+			})
+		}
+	}
+	return res
+}
+
+func isEmptyJSON(raw json.RawMessage) bool {
+	switch string(raw) {
+	case "", "null", "[]", "{}", `""`:
+		return true
+	}
+	return false
+}
+
+// schoolScoreEndpoint is the 院校分数线, stored like special_detail/.
+var schoolScoreEndpoint = &endpoint{
+	Stage:     stageSchoolScore,
+	URLFormat: "https://static-data.gaokao.cn/www/2.0/schoolprovinceindex/%v/%v/%v/%v/%v/%v.json",
+	Dims:      ytbDims,
+	Paging:    pageIndex,
+	Response:  SchoolScore{},
+	Dir:       schoolScoreDir,
+	Build: func(task endpointTask, responses []endpointResponse) (interface{}, error) {
+		score := SchoolProvScore{
+			SchoolID:     task.Key[0],
+			ProvinceID:   task.Key[1],
+			ProvinceName: provinceDict.name(task.Key[1]),
+			BatchGroups:  loadBatchGroups(task.Key[0], task.Key[1]),
+			Lines:        make([]SchoolScoreLine, 0),
+		}
+		for _, resp := range responses {
+			var items []SchoolScoreItem
+			if err := decodeItems(resp.Items, &items); err != nil {
+				return nil, err
+			}
+			year, typ, batch := ytbOf(resp.Args)
+			for _, item := range items {
+				score.Lines = append(score.Lines, item.Line(year, typ, batch))
+			}
+		}
+		return score, nil
+	},
+}
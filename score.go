@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const specialScoreDir = "school_special_score"
+
+// SchoolSpecialScore is the response of schoolspecialscore, data is keyed by
+// "<type>_<batch>_<x>", e.g. 1_7_0 理科一本, 2_8_0 文科二本.
+type SchoolSpecialScore struct {
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+// specialScoreTasks are the (school, year, province) tasks of the special score stage.
+func specialScoreTasks(schoolIDs, years, provinces []string) []endpointTask {
+	tasks := make([]endpointTask, 0, len(schoolIDs)*len(years)*len(provinces))
+	for _, id := range schoolIDs {
+		for _, year := range years {
+			for _, prov := range provinces {
+				tasks = append(tasks, endpointTask{Key: []string{id, year, prov}, Requests: [][]string{{id, year, prov}}})
+			}
+		}
+	}
+	return tasks
+}
+
+// parseIntList parses "2018,2019,2020" into its elements.
+func parseIntList(s string) ([]string, error) {
+	res := make([]string, 0)
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(field); err != nil {
+			return nil, fmt.Errorf("invalid number %q in %q", field, s)
+		}
+		res = append(res, field)
+	}
+	return res, nil
+}
+
+// specialScoreEndpoint is the 专业分数线 of a school, year and province, stored as
+// school_special_score/<school>_<year>_<province>.json. like python_impl_2024 only the
+// data part is kept.
+var specialScoreEndpoint = &endpoint{
+	Stage:     stageSpecialScore,
+	URLFormat: "https://static-data.gaokao.cn/www/2.0/schoolspecialscore/%v/%v/%v.json",
+	Dims:      []string{"school", "year", "province"},
+	Response:  SchoolSpecialScore{},
+	Dir:       specialScoreDir,
+	Build: func(task endpointTask, responses []endpointResponse) (interface{}, error) {
+		return responses[0].Data, nil
+	},
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+// sink kinds and compressions
+const (
+	sinkFile  = "file"
+	sinkJSONL = "jsonl"
+
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// sink stores the records of a crawl. a dataset is an output directory, a key names the
+// record within it, e.g. special_detail and 31_45. a later record of a key replaces
+// an earlier one.
+type sink interface {
+	write(dataset, key string, v interface{}) error
+	close() error
+}
+
+// outputSink is where the crawl stores its records, per file unless configured otherwise.
+var outputSink sink = fileSink{}
+
+// rawResponse is an upstream response stored byte for byte.
+type rawResponse []byte
+
+func (r rawResponse) MarshalJSON() ([]byte, error) {
+	return r, nil
+}
+
+func newSink(kind, compress string, rotateBytes int64) (sink, error) {
+	switch kind {
+	case sinkFile:
+		return fileSink{}, nil
+	case sinkJSONL:
+		if _, ok := jsonlExtensions[compress]; !ok {
+			return nil, fmt.Errorf("unknown sink compression %q", compress)
+		}
+		return &jsonlSink{compress: compress, rotateBytes: rotateBytes, parts: make(map[string]*jsonlPart)}, nil
+	}
+	return nil, fmt.Errorf("unknown sink %q", kind)
+}
+
+// fileSink writes every record to its own pretty printed <dataset>/<key>.json.
+type fileSink struct{}
+
+func (fileSink) write(dataset, key string, v interface{}) error {
+	file := path.Join(dataset, key+".json")
+	if raw, ok := v.(rawResponse); ok {
+		return os.WriteFile(file, raw, 0666)
+	}
+	return writeJSON(file, v)
+}
+
+func (fileSink) close() error {
+	return nil
+}
+
+// sinkRecord is a line of a jsonl part.
+type sinkRecord struct {
+	Key  string
+	Data interface{}
+}
+
+var jsonlExtensions = map[string]string{
+	compressNone: ".jsonl",
+	compressGzip: ".jsonl.gz",
+	compressZstd: ".jsonl.zst",
+}
+
+// jsonlPartName matches <dataset>/part-<index>.jsonl[.gz|.zst].
+var jsonlPartName = regexp.MustCompile(`^part-(\d+)\.jsonl(\.gz|\.zst)?$`)
+
+// jsonlSink appends records as json lines to <dataset>/part-<index>.jsonl, compressed
+// on request. a part is closed and the next one started once its records reach
+// rotateBytes before compression, a run never appends to the parts of an earlier run.
+type jsonlSink struct {
+	compress    string
+	rotateBytes int64
+
+	mu    sync.Mutex
+	parts map[string]*jsonlPart
+}
+
+type jsonlPart struct {
+	index   int
+	file    *os.File
+	written *countingWriter // record bytes, before compression
+	zw      io.WriteCloser  // nil when not compressed
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// nextPartIndex returns the index after the last part in dir.
+func nextPartIndex(dir string) int {
+	entries, _ := os.ReadDir(dir)
+	next := 1
+	for _, e := range entries {
+		if m := jsonlPartName.FindStringSubmatch(e.Name()); m != nil {
+			if i, _ := strconv.Atoi(m[1]); i >= next {
+				next = i + 1
+			}
+		}
+	}
+	return next
+}
+
+func (s *jsonlSink) open(dataset string, index int) (*jsonlPart, error) {
+	if err := os.MkdirAll(dataset, 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path.Join(dataset, fmt.Sprintf("part-%05d%v", index, jsonlExtensions[s.compress])))
+	if err != nil {
+		return nil, err
+	}
+	p := &jsonlPart{index: index, file: f}
+	var w io.Writer = f
+	switch s.compress {
+	case compressGzip:
+		p.zw = gzip.NewWriter(f)
+		w = p.zw
+	case compressZstd:
+		if p.zw, err = zstd.NewWriter(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		w = p.zw
+	}
+	p.buf = bufio.NewWriter(w)
+	p.written = &countingWriter{w: p.buf}
+	p.encoder = json.NewEncoder(p.written)
+	p.encoder.SetEscapeHTML(false)
+	return p, nil
+}
+
+func (p *jsonlPart) close() error {
+	if err := p.buf.Flush(); err != nil {
+		return err
+	}
+	if p.zw != nil {
+		if err := p.zw.Close(); err != nil {
+			return err
+		}
+	}
+	return p.file.Close()
+}
+
+func (s *jsonlSink) write(dataset, key string, v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.parts[dataset]
+	if !ok {
+		var err error
+		if p, err = s.open(dataset, nextPartIndex(dataset)); err != nil {
+			return err
+		}
+		s.parts[dataset] = p
+	}
+	if err := p.encoder.Encode(sinkRecord{Key: key, Data: v}); err != nil {
+		return err
+	}
+	if s.rotateBytes > 0 && p.written.n >= s.rotateBytes {
+		if err := p.close(); err != nil {
+			return err
+		}
+		next, err := s.open(dataset, p.index+1)
+		if err != nil {
+			delete(s.parts, dataset)
+			return err
+		}
+		s.parts[dataset] = next
+	}
+	return nil
+}
+
+func (s *jsonlSink) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for dataset, p := range s.parts {
+		if err := p.close(); err != nil {
+			return fmt.Errorf("%v: %w", dataset, err)
+		}
+		delete(s.parts, dataset)
+	}
+	return nil
+}
+
+// datasetRecord is a record read back from either sink.
+type datasetRecord struct {
+	Key    string
+	Data   json.RawMessage
+	Source string // file, or part file and line
+}
+
+// readDataset reads the records of dir written by any sink: <key>.json files first,
+// then jsonl parts in order. when a key occurs more than once only its last written
+// record is read: within the parts the later line, between a file and a part the one
+// modified last, so a file rewritten after a jsonl run replaces the record of its part.
+// keep, when set, filters keys before their data is handed to fn.
+func readDataset(dir string, keep func(key string) bool, fn func(rec datasetRecord) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	files, parts := make([]os.DirEntry, 0), make([]os.DirEntry, 0)
+	for _, e := range entries {
+		switch {
+		case e.IsDir():
+		case jsonlPartName.MatchString(e.Name()):
+			parts = append(parts, e)
+		case strings.HasSuffix(e.Name(), ".json"):
+			files = append(files, e)
+		}
+	}
+	// ReadDir sorts by name, part indexes are zero padded
+
+	// the last occurrence of every key in the parts, and when its part was written
+	type occurrence struct {
+		ordinal int
+		written time.Time
+	}
+	last := make(map[string]occurrence)
+	ordinal := 0
+	for _, part := range parts {
+		info, err := part.Info()
+		if err != nil {
+			return err
+		}
+		err = readPart(path.Join(dir, part.Name()), func(line int, key string, data json.RawMessage) error {
+			if keep == nil || keep(key) {
+				last[key] = occurrence{ordinal, info.ModTime()}
+			}
+			ordinal++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, e := range files {
+		key := strings.TrimSuffix(e.Name(), ".json")
+		if keep != nil && !keep(key) {
+			continue
+		}
+		if o, ok := last[key]; ok {
+			info, err := e.Info()
+			if err != nil {
+				return err
+			}
+			if !info.ModTime().After(o.written) {
+				continue
+			}
+			delete(last, key)
+		}
+		file := path.Join(dir, e.Name())
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := fn(datasetRecord{Key: key, Data: content, Source: file}); err != nil {
+			return err
+		}
+	}
+	if len(last) == 0 {
+		return nil
+	}
+	ordinal = 0
+	for _, part := range parts {
+		file := path.Join(dir, part.Name())
+		err := readPart(file, func(line int, key string, data json.RawMessage) error {
+			defer func() { ordinal++ }()
+			if o, ok := last[key]; !ok || o.ordinal != ordinal {
+				return nil
+			}
+			return fn(datasetRecord{Key: key, Data: data, Source: fmt.Sprintf("%v:%v", file, line)})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPart decodes the lines of a jsonl part, compressed or not.
+func readPart(file string, fn func(line int, key string, data json.RawMessage) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(file, ".gz"):
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%v: %w", file, err)
+		}
+		defer zr.Close()
+		r = zr
+	case strings.HasSuffix(file, ".zst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%v: %w", file, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 256*1024*1024)
+	// a crawl killed mid write leaves a torn last line, or a truncated compressed
+	// stream. a line which does not decode is only an error when another follows it.
+	var torn int
+	var tornErr error
+	for line := 1; scanner.Scan(); line++ {
+		if torn != 0 {
+			return fmt.Errorf("%v:%v: %w", file, torn, tornErr)
+		}
+		var rec struct {
+			Key  string
+			Data json.RawMessage
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			torn, tornErr = line, err
+			continue
+		}
+		if err := fn(line, rec.Key, rec.Data); err != nil {
+			return err
+		}
+	}
+	err = scanner.Err()
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%v: %w", file, err)
+	}
+	if torn != 0 {
+		log.Warnw("torn last line of jsonl part skipped", zap.String("file", file), zap.Int("line", torn), zap.NamedError("reason", tornErr))
+	}
+	if err != nil {
+		log.Warnw("truncated jsonl part, its rest is skipped", zap.String("file", file), zap.Error(err))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// readBack reads dir into key -> data.
+func readBack(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	res := make(map[string]string)
+	err := readDataset(dir, nil, func(rec datasetRecord) error {
+		var v interface{}
+		if err := json.Unmarshal(rec.Data, &v); err != nil {
+			return err
+		}
+		res[rec.Key] = fmt.Sprint(v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestJSONLSinkReadback(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	for _, compress := range []string{compressNone, compressGzip, compressZstd} {
+		t.Run(compress, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "special_detail")
+			s, err := newSink(sinkJSONL, compress, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				if err := s.write(dir, fmt.Sprintf("%v_45", i%4), map[string]int{"n": i}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.close(); err != nil {
+				t.Fatal(err)
+			}
+			// every record is over 20 bytes, a part holds at most three
+			parts, _ := filepath.Glob(filepath.Join(dir, "part-*"+jsonlExtensions[compress]))
+			if len(parts) < 4 {
+				t.Errorf("%v parts, want rotation into at least 4", len(parts))
+			}
+			want := map[string]string{"0_45": "map[n:8]", "1_45": "map[n:9]", "2_45": "map[n:6]", "3_45": "map[n:7]"}
+			if got := readBack(t, dir); !reflect.DeepEqual(got, want) {
+				t.Errorf("read %v, want %v", got, want)
+			}
+
+			// a later run starts a new part, its records replace the earlier ones
+			next := nextPartIndex(dir)
+			s, _ = newSink(sinkJSONL, compress, 0)
+			if err := s.write(dir, "0_45", map[string]int{"n": 100}); err != nil {
+				t.Fatal(err)
+			}
+			s.close()
+			if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("part-%05d%v", next, jsonlExtensions[compress]))); err != nil {
+				t.Error(err)
+			}
+			if got := readBack(t, dir)["0_45"]; got != "map[n:100]" {
+				t.Errorf("0_45 read %v after a later run", got)
+			}
+		})
+	}
+}
+
+func TestReadDatasetFileAndPart(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	write := func(name, content string, mtime time.Time) {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 1 was crawled per file after the jsonl run, 2 before it
+	write("part-00001.jsonl", `{"Key":"1","Data":"part"}`+"\n"+`{"Key":"2","Data":"part"}`+"\n", old)
+	write("1.json", `"file"`, time.Now())
+	write("2.json", `"file"`, old.Add(-time.Hour))
+	write("3.json", `"file"`, old)
+	want := map[string]string{"1": "file", "2": "part", "3": "file"}
+	if got := readBack(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v, want %v", got, want)
+	}
+}
+
+func TestReadPartTornLine(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "part-00001.jsonl")
+	complete := `{"Key":"1","Data":1}` + "\n" + `{"Key":"2","Data":2}` + "\n"
+	os.WriteFile(file, []byte(complete+`{"Key":"3","Da`), 0666)
+	keys := make([]string, 0)
+	err := readPart(file, func(line int, key string, data json.RawMessage) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(keys, []string{"1", "2"}) {
+		t.Errorf("torn last line: keys %v, err %v", keys, err)
+	}
+
+	// a broken line in the middle is not a torn write
+	os.WriteFile(file, []byte(`{"Key":"1","Da`+"\n"+complete), 0666)
+	if err := readPart(file, func(int, string, json.RawMessage) error { return nil }); err == nil {
+		t.Error("broken first line read")
+	}
+}
+
+func TestReadPartTruncatedGzip(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	s, _ := newSink(sinkJSONL, compressGzip, 0)
+	for i := 0; i < 1000; i++ {
+		s.write(dir, fmt.Sprint(i), i)
+	}
+	s.close()
+	file := filepath.Join(dir, "part-00001.jsonl.gz")
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crawl killed halfway through the part
+	os.WriteFile(file, content[:len(content)/2], 0666)
+	n := 0
+	err = readPart(file, func(int, string, json.RawMessage) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n == 1000 {
+		t.Errorf("%v records read from a truncated part", n)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteFile = "gk-score.db"
+
+// sqliteSchema is the relational layout of a crawl output. codes reference the
+// dictionary tables, specials are keyed by school, province, year, type, batch and
+// the upstream special identity (special_id, spe_id, zslx, special_group).
+var sqliteSchema = []string{
+	`CREATE TABLE meta (key TEXT PRIMARY KEY, value TEXT NOT NULL)`,
+	`CREATE TABLE provinces (id TEXT PRIMARY KEY, name_zh TEXT NOT NULL, name_en TEXT NOT NULL)`,
+	`CREATE TABLE types (id TEXT PRIMARY KEY, name_zh TEXT NOT NULL, name_en TEXT NOT NULL)`,
+	`CREATE TABLE batches (id TEXT PRIMARY KEY, name_zh TEXT NOT NULL, name_en TEXT NOT NULL)`,
+	`CREATE TABLE schools (
+		school_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		old_name TEXT,
+		is_985 INTEGER NOT NULL,
+		is_211 INTEGER NOT NULL,
+		is_dual_class INTEGER NOT NULL,
+		dual_class_name TEXT,
+		belong TEXT,
+		city_id TEXT,
+		city_name TEXT,
+		county_id TEXT,
+		address TEXT,
+		level_name TEXT,
+		nature_name TEXT,
+		school_type_name TEXT,
+		type_name TEXT,
+		num_academician INTEGER,
+		num_doctor INTEGER,
+		num_master INTEGER,
+		num_subject INTEGER,
+		num_lab INTEGER,
+		qs_rank INTEGER,
+		qs_world INTEGER,
+		ruanke_rank INTEGER
+	)`,
+	`CREATE TABLE dual_class_subjects (
+		school_id TEXT NOT NULL REFERENCES schools(school_id),
+		subject_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		PRIMARY KEY (school_id, subject_id)
+	)`,
+	`CREATE TABLE ptb (
+		year INTEGER NOT NULL,
+		school_id TEXT NOT NULL,
+		province_id TEXT NOT NULL REFERENCES provinces(id),
+		type TEXT NOT NULL REFERENCES types(id),
+		batch TEXT NOT NULL REFERENCES batches(id),
+		PRIMARY KEY (school_id, province_id, year, type, batch)
+	)`,
+	`CREATE TABLE specials (
+		id INTEGER PRIMARY KEY,
+		school_id TEXT NOT NULL,
+		province_id TEXT NOT NULL REFERENCES provinces(id),
+		year INTEGER NOT NULL,
+		type TEXT NOT NULL REFERENCES types(id),
+		batch TEXT NOT NULL REFERENCES batches(id),
+		special_id TEXT NOT NULL,
+		spe_id TEXT NOT NULL,
+		zslx TEXT NOT NULL,
+		special_group TEXT NOT NULL,
+		special_name TEXT NOT NULL,
+		zslx_name TEXT,
+		local_batch_name TEXT,
+		level1_name TEXT,
+		level2_name TEXT,
+		level3_name TEXT,
+		max REAL,
+		min REAL,
+		average REAL,
+		min_section INTEGER,
+		warnings TEXT,
+		UNIQUE (school_id, province_id, year, type, batch, special_id, spe_id, zslx, special_group, special_name)
+	)`,
+	`CREATE INDEX specials_lookup ON specials (province_id, year, type, batch)`,
+	`CREATE INDEX specials_special ON specials (special_id)`,
+	`CREATE INDEX specials_name ON specials (special_name)`,
+	`CREATE INDEX specials_rank ON specials (province_id, year, min_section)`,
+	`CREATE INDEX ptb_province ON ptb (province_id, year)`,
+	`CREATE INDEX schools_name ON schools (name)`,
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// sourceFingerprint hashes name, size and mtime of every file exportSQLite reads, files
+// rewritten in place or appended to change it while their directory mtime stays.
+func sourceFingerprint(dir string) (string, error) {
+	h := sha256.New()
+	for _, source := range []string{schoolInfoDir, schoolPTBFile, specialDetailDir} {
+		p := path.Join(dir, source)
+		stat, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		stats := []os.FileInfo{stat}
+		if stat.IsDir() {
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return "", err
+			}
+			stats = stats[:0]
+			for _, e := range entries {
+				info, err := e.Info()
+				if err != nil {
+					return "", err
+				}
+				stats = append(stats, info)
+			}
+		}
+		for _, info := range stats {
+			fmt.Fprintf(h, "%v/%v %v %v\n", source, info.Name(), info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// exportSQLite loads the crawl output in dir into a new SQLite file out.
+func exportSQLite(dir, out string) error {
+	if err := os.Remove(out); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fingerprint, err := sourceFingerprint(dir)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", out)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range sqliteSchema {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("%v: %w", strings.SplitN(stmt, "(", 2)[0], err)
+		}
+	}
+	meta := map[string]string{
+		"exported_at":  time.Now().Format(time.RFC3339),
+		"source_dir":   dir,
+		"dict_version": provinceDict.Version,
+		"fingerprint":  fingerprint,
+	}
+	for k, v := range meta {
+		if _, err := tx.Exec(`INSERT INTO meta VALUES (?, ?)`, k, v); err != nil {
+			return err
+		}
+	}
+	for table, dict := range map[string]*codeDict{"provinces": provinceDict, "types": typeDict, "batches": batchDict} {
+		for code, name := range dict.Codes {
+			if _, err := tx.Exec(`INSERT INTO `+table+` VALUES (?, ?, ?)`, code, name.ZH, name.EN); err != nil {
+				return err
+			}
+		}
+	}
+	schools, err := sqliteSchools(tx, path.Join(dir, schoolInfoDir))
+	if err != nil {
+		return err
+	}
+	ptbs, err := sqlitePTB(tx, path.Join(dir, schoolPTBFile))
+	if err != nil {
+		return err
+	}
+	specials, err := sqliteSpecials(tx, path.Join(dir, specialDetailDir))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Infow("sqlite exported",
+		zap.String("file", out),
+		zap.Int("schools", schools),
+		zap.Int("ptb", ptbs),
+		zap.Int("specials", specials))
+	return nil
+}
+
+// sqliteSchools loads school_info/ profiles into schools and dual_class_subjects.
+func sqliteSchools(tx *sql.Tx, dir string) (int, error) {
+	profiles, err := loadSchoolProfiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	insertSchool, err := tx.Prepare(`INSERT OR REPLACE INTO schools VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insertSchool.Close()
+	insertSubject, err := tx.Prepare(`INSERT OR IGNORE INTO dual_class_subjects VALUES (?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insertSubject.Close()
+	for _, p := range profiles {
+		_, err = insertSchool.Exec(p.SchoolID, p.Name, p.OldName, boolInt(p.Is985), boolInt(p.Is211), boolInt(p.IsDualClass),
+			p.DualClassName, p.Belong, p.CityID, p.CityName, p.CountyID, p.Address, p.LevelName, p.NatureName,
+			p.SchoolTypeName, p.TypeName, p.NumAcademician, p.NumDoctor, p.NumMaster, p.NumSubject, p.NumLab,
+			p.QsRank, p.QsWorld, p.RuankeRank)
+		if err != nil {
+			return 0, fmt.Errorf("school %v: %w", p.SchoolID, err)
+		}
+		for _, sub := range p.DualClassSubjects {
+			if _, err := insertSubject.Exec(p.SchoolID, sub.ID, sub.Name); err != nil {
+				return 0, fmt.Errorf("school %v: %w", p.SchoolID, err)
+			}
+		}
+	}
+	return len(profiles), nil
+}
+
+// sqlitePTB loads ptb.txt, repeated lines of resumed runs collapse on the primary key.
+func sqlitePTB(tx *sql.Tx, file string) (int, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	insert, err := tx.Prepare(`INSERT OR IGNORE INTO ptb VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		// year, school, prov, type, batch
+		// malformed lines are left to validate
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 5 {
+			log.Warnw("ptb line skipped", zap.String("file", file), zap.Int("line", line))
+			continue
+		}
+		year, err := strconv.Atoi(fields[0])
+		if err != nil {
+			log.Warnw("ptb line skipped", zap.String("file", file), zap.Int("line", line), zap.Error(err))
+			continue
+		}
+		res, err := insert.Exec(year, fields[1], fields[2], fields[3], fields[4])
+		if err != nil {
+			return count, fmt.Errorf("%v:%v: %w", file, line, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// sqliteSpecials loads special_detail/ as canonical admission scores.
+func sqliteSpecials(tx *sql.Tx, dir string) (int, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return 0, nil
+	}
+	insert, err := tx.Prepare(`INSERT OR REPLACE INTO specials (school_id, province_id, year, type, batch,
+		special_id, spe_id, zslx, special_group, special_name, zslx_name, local_batch_name,
+		level1_name, level2_name, level3_name, max, min, average, min_section, warnings)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+	count := 0
+	err = walkSpecialDetail(dir, nil, func(file string, sp SchoolProv) error {
+		for _, a := range admissionScoresFromSchoolProv(sp, file) {
+			var warnings interface{}
+			if len(a.Warnings) != 0 {
+				sort.Strings(a.Warnings)
+				warnings = strings.Join(a.Warnings, "; ")
+			}
+			_, err := insert.Exec(a.SchoolID, a.ProvinceID, a.Year, a.Type, a.Batch,
+				a.SpecialID, a.SpeID, a.Zslx, a.SpecialGroup, a.SpecialName, a.ZslxName, a.LocalBatchName,
+				a.Level1Name, a.Level2Name, a.Level3Name, a.Max, a.Min, a.Average, a.MinSection, warnings)
+			if err != nil {
+				return fmt.Errorf("%v: %w", file, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// sqliteCommand exports a crawl output into one SQLite file.
+func sqliteCommand(args []string) error {
+	fs := flag.NewFlagSet("sqlite", flag.ExitOnError)
+	dir := fs.String("dir", ".", "crawl output directory")
+	out := fs.String("out", sqliteFile, "sqlite file, replaced when it exists")
+	must(fs.Parse(args))
+	return exportSQLite(*dir, *out)
+}
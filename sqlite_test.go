@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestExportSQLite(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	// a resumed run repeats the lines of an interrupted school
+	ptb := "2023,31,45,1,7\n2023,31,45,2,7\n2023,31,45,1,7\nbroken\n"
+	if err := os.WriteFile(filepath.Join(dir, schoolPTBFile), []byte(ptb), 0666); err != nil {
+		t.Fatal(err)
+	}
+	special := func(group string, min float64) TypedSpecial {
+		s := testSpecial("数学", min, 3000)
+		s.SpecialGroup = group
+		return s
+	}
+	os.Mkdir(filepath.Join(dir, specialDetailDir), 0777)
+	sp := SchoolProv{SchoolID: "31", ProvinceID: "45", YTBSpecials: []YTBSpecial{
+		{Year: "2023", Typ: "1", Batch: "7", Special: []TypedSpecial{special("1", 600), special("2", 590), special("1", 605)}},
+	}}
+	if err := writeJSON(filepath.Join(dir, specialDetailDir, "31_45.json"), sp); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, sqliteFile)
+	if err := exportSQLite(dir, out); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	count := func(query string, args ...interface{}) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	for _, name := range []string{"meta", "provinces", "types", "batches", "schools", "dual_class_subjects", "ptb", "specials",
+		"specials_lookup", "specials_rank", "ptb_province", "schools_name"} {
+		if count(`SELECT count(*) FROM sqlite_master WHERE name = ?`, name) != 1 {
+			t.Errorf("%v not created", name)
+		}
+	}
+	if n := count(`SELECT count(*) FROM provinces`); n != len(provinceDict.Codes) {
+		t.Errorf("%v provinces, want %v", n, len(provinceDict.Codes))
+	}
+	if count(`SELECT count(*) FROM meta WHERE key = 'fingerprint' AND value != ''`) != 1 {
+		t.Error("no source fingerprint")
+	}
+
+	if n := count(`SELECT count(*) FROM ptb`); n != 2 {
+		t.Errorf("%v ptb rows, want repeated lines collapsed into 2", n)
+	}
+
+	// specials of two groups stay apart, a special listed twice keeps its last row
+	if n := count(`SELECT count(*) FROM specials`); n != 2 {
+		t.Errorf("%v specials, want 2", n)
+	}
+	var min float64
+	if err := db.QueryRow(`SELECT min FROM specials WHERE special_group = '1'`).Scan(&min); err != nil || min != 605 {
+		t.Errorf("group 1 min %v %v, want 605", min, err)
+	}
+	_, err = db.Exec(`INSERT INTO specials (school_id, province_id, year, type, batch, special_id, spe_id, zslx, special_group, special_name)
+		VALUES ('31', '45', 2023, '1', '7', '数学', '', '', '2', '数学')`)
+	if err == nil {
+		t.Error("special inserted twice under its unique key")
+	}
+}
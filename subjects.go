@@ -0,0 +1,336 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// selectable subjects of the new gaokao, 技术 is zhejiang only
+const (
+	subjectPhysics   = "物理"
+	subjectChemistry = "化学"
+	subjectBiology   = "生物"
+	subjectHistory   = "历史"
+	subjectGeography = "地理"
+	subjectPolitics  = "政治"
+	subjectTech      = "技术"
+)
+
+// subjectAliases maps names seen upstream and in user input to a canonical subject, longest first.
+var subjectAliases = []struct {
+	alias   string
+	subject string
+}{
+	{"思想政治", subjectPolitics},
+	{"通用技术", subjectTech},
+	{"信息技术", subjectTech},
+	{"physics", subjectPhysics},
+	{"chemistry", subjectChemistry},
+	{"biology", subjectBiology},
+	{"history", subjectHistory},
+	{"geography", subjectGeography},
+	{"politics", subjectPolitics},
+	{"物理", subjectPhysics},
+	{"化学", subjectChemistry},
+	{"生物", subjectBiology},
+	{"历史", subjectHistory},
+	{"地理", subjectGeography},
+	{"政治", subjectPolitics},
+	{"技术", subjectTech},
+}
+
+// subjectAbbreviations are the one character forms of subjects, as in 物化生.
+var subjectAbbreviations = map[rune]string{
+	'物': subjectPhysics,
+	'化': subjectChemistry,
+	'生': subjectBiology,
+	'史': subjectHistory,
+	'地': subjectGeography,
+	'政': subjectPolitics,
+	'技': subjectTech,
+}
+
+// words telling that one of the listed subjects is enough
+var anyOfMarkers = []string{"或", "/", "／", "任选", "选一", "选1", "其中一门", "之一", "一门即可", "1门即可", "均可"}
+
+// requirement modes of the re-selected subjects
+const (
+	requireNone = "none" // no restriction
+	requireAll  = "all"  // every subject is required
+	requireAny  = "any"  // one of the subjects is enough
+	requireSome = "some" // Count of the subjects are required, e.g. 3选2
+)
+
+// nOfM matches "3选2", "三选二" or "(3选2)": M listed subjects, N of them required.
+var nOfM = regexp.MustCompile(`([1-7一二两三四五六七])\s*选\s*([1-7一二两三四五六七])`)
+
+var chineseDigits = map[string]int{"一": 1, "二": 2, "两": 2, "三": 3, "四": 4, "五": 5, "六": 6, "七": 7}
+
+func smallNumber(s string) int {
+	if n, ok := chineseDigits[s]; ok {
+		return n
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// SubjectRequirement is the subject selection requirement of a special or a special group.
+type SubjectRequirement struct {
+	First    string   // first subject of 3+1+2 provinces, 物理 or 历史, empty when not restricted
+	Required []string // re-selected subjects
+	Mode     string   // none, all, any or some
+	Count    int      `json:",omitempty"` // subjects required of Required when Mode is some
+	Raw      string   // upstream text the requirement was parsed from
+	Parsed   bool     // false when the raw text could not be understood, Mode is none then
+}
+
+// parseSubjects returns canonical subjects in s in order of appearance, without duplicates.
+func parseSubjects(s string) []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	s = expandAbbreviations(strings.ToLower(s))
+	for len(s) > 0 {
+		matched := false
+		for _, a := range subjectAliases {
+			if strings.HasPrefix(s, a.alias) {
+				if !seen[a.subject] {
+					seen[a.subject] = true
+					res = append(res, a.subject)
+				}
+				s = s[len(a.alias):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			// skip one rune
+			_, size := utf8.DecodeRuneInString(s)
+			s = s[size:]
+		}
+	}
+	return res
+}
+
+// expandAbbreviations spells out runs of two or more one character subjects, "物化生"
+// gives "物理、化学、生物". full names like 生物 are kept, a single character is no subject.
+func expandAbbreviations(s string) string {
+	var b strings.Builder
+	run := make([]rune, 0)
+	flush := func() {
+		if len(run) < 2 {
+			b.WriteString(string(run))
+		} else {
+			names := make([]string, 0, len(run))
+			for _, c := range run {
+				names = append(names, subjectAbbreviations[c])
+			}
+			b.WriteString(strings.Join(names, "、"))
+		}
+		run = run[:0]
+	}
+	for len(s) > 0 {
+		alias := ""
+		for _, a := range subjectAliases {
+			if strings.HasPrefix(s, a.alias) {
+				alias = a.alias
+				break
+			}
+		}
+		if alias != "" {
+			flush()
+			b.WriteString(alias)
+			s = s[len(alias):]
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s)
+		if _, ok := subjectAbbreviations[c]; ok {
+			run = append(run, c)
+		} else {
+			flush()
+			b.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
+	flush()
+	return b.String()
+}
+
+// parseRequirement parses upstream selection text like "首选物理，再选化学",
+// "物理和化学", "物化生", "化学或生物(2选1)" or "物化生(3选2)". first is a hint of the first subject
+// from other fields, used when the text does not name one.
+func parseRequirement(info, first string) SubjectRequirement {
+	r := SubjectRequirement{Raw: info, Mode: requireNone, Required: make([]string, 0), First: first}
+	text := strings.TrimSpace(info)
+	if text == "" || text == "-" {
+		r.Parsed = first != ""
+		return r
+	}
+	reselect := text
+	if i := strings.Index(text, "首选"); i >= 0 {
+		rest := text[i+len("首选"):]
+		if subjects := parseSubjects(firstClause(rest)); len(subjects) != 0 {
+			r.First = subjects[0]
+		}
+		if j := strings.Index(rest, "再选"); j >= 0 {
+			reselect = rest[j+len("再选"):]
+		} else {
+			// "首选物理，化学必选"
+			reselect = rest[len(firstClause(rest)):]
+		}
+	}
+	subjects := parseSubjects(reselect)
+	// subjects named outside the understood parts, e.g. "化学(首选物理)"
+	consumed := map[string]bool{r.First: true}
+	for _, s := range subjects {
+		consumed[s] = true
+	}
+	for _, s := range parseSubjects(text) {
+		if !consumed[s] {
+			r.Parsed = false
+			return r
+		}
+	}
+	if r.First != "" {
+		// the first subject repeated in the re-selected part is no extra requirement
+		filtered := subjects[:0]
+		for _, s := range subjects {
+			if s != r.First {
+				filtered = append(filtered, s)
+			}
+		}
+		subjects = filtered
+	}
+	if m := nOfM.FindStringSubmatch(reselect); m != nil {
+		total, n := smallNumber(m[1]), smallNumber(m[2])
+		if total != len(subjects) || n < 1 || n > total {
+			// "化学(2选1)" does not list what the other subject is
+			r.Parsed = false
+			return r
+		}
+		r.Required, r.Parsed = subjects, true
+		switch n {
+		case 1:
+			r.Mode = requireAny
+		case total:
+			r.Mode = requireAll
+		default:
+			r.Mode, r.Count = requireSome, n
+		}
+		return r
+	}
+	switch {
+	case len(subjects) == 0:
+		r.Parsed = strings.Contains(text, "不限") || r.First != "" || strings.Contains(text, "首选")
+	case len(subjects) == 1:
+		r.Mode, r.Required, r.Parsed = requireAll, subjects, true
+	default:
+		r.Mode, r.Required, r.Parsed = requireAll, subjects, true
+		for _, m := range anyOfMarkers {
+			if strings.Contains(reselect, m) {
+				r.Mode = requireAny
+				break
+			}
+		}
+	}
+	return r
+}
+
+// firstClause cuts s at the first separator, "物理，再选化学" gives "物理".
+func firstClause(s string) string {
+	if i := strings.IndexAny(s, ",，;；。 "); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// Eligible tells whether a student who selected subjects satisfies the requirement.
+// certain is false when the requirement text could not be parsed, eligible is true then.
+func (r SubjectRequirement) Eligible(subjects []string) (eligible, certain bool) {
+	has := make(map[string]bool)
+	for _, s := range subjects {
+		for _, p := range parseSubjects(s) {
+			has[p] = true
+		}
+	}
+	if r.First != "" && !has[r.First] {
+		return false, true
+	}
+	if !r.Parsed {
+		return true, false
+	}
+	switch r.Mode {
+	case requireAll:
+		for _, s := range r.Required {
+			if !has[s] {
+				return false, true
+			}
+		}
+	case requireSome:
+		n := 0
+		for _, s := range r.Required {
+			if has[s] {
+				n++
+			}
+		}
+		return n >= r.Count, true
+	case requireAny:
+		for _, s := range r.Required {
+			if has[s] {
+				return true, true
+			}
+		}
+		return false, true
+	}
+	return true, true
+}
+
+// firstSubjectHint works out the first subject of a special from first_km and the type.
+func (s Special) firstSubjectHint() string {
+	if subjects := parseSubjects(s.FirstKm); len(subjects) != 0 &&
+		(subjects[0] == subjectPhysics || subjects[0] == subjectHistory) {
+		return subjects[0]
+	}
+	switch s.Type {
+	case "2073":
+		return subjectPhysics
+	case "2074":
+		return subjectHistory
+	}
+	return ""
+}
+
+// SpecialRequirement parses the selection requirement of the special itself.
+func (s Special) SpecialRequirement() SubjectRequirement {
+	return parseRequirement(requirementText(s.SpInfo, s.SpSxk, s.SpFxk), s.firstSubjectHint())
+}
+
+// GroupRequirement parses the selection requirement of the special group the special belongs to.
+func (s Special) GroupRequirement() SubjectRequirement {
+	return parseRequirement(requirementText(s.SgInfo, s.SgSxk, s.SgFxk), s.firstSubjectHint())
+}
+
+// requirementText prefers the descriptive info, and falls back to the first (sxk) and
+// re-selected (fxk) subject fields.
+func requirementText(info, sxk, fxk string) string {
+	if strings.TrimSpace(info) != "" {
+		return info
+	}
+	if sxk == "" && fxk == "" {
+		return ""
+	}
+	text := ""
+	if sxk != "" {
+		text = "首选" + sxk + "，"
+	}
+	return text + "再选" + fxk
+}
+
+// EligibleFor tells whether a student with the selected subjects may apply for the
+// special, both the special and its group requirement have to be met.
+func (s Special) EligibleFor(subjects []string) (eligible, certain bool) {
+	sp, spCertain := s.SpecialRequirement().Eligible(subjects)
+	sg, sgCertain := s.GroupRequirement().Eligible(subjects)
+	return sp && sg, spCertain && sgCertain
+}
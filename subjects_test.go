@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRequirement(t *testing.T) {
+	for _, c := range []struct {
+		text     string
+		first    string // hint
+		want     SubjectRequirement
+		wantNone bool // only Parsed and First are checked
+	}{
+		{text: "首选物理，再选化学", want: SubjectRequirement{First: "物理", Required: []string{"化学"}, Mode: requireAll, Parsed: true}},
+		{text: "首选物理，再选化学和生物", want: SubjectRequirement{First: "物理", Required: []string{"化学", "生物"}, Mode: requireAll, Parsed: true}},
+		{text: "首选历史，再选不限", want: SubjectRequirement{First: "历史", Required: []string{}, Mode: requireNone, Parsed: true}},
+		{text: "首选物理，化学必选", want: SubjectRequirement{First: "物理", Required: []string{"化学"}, Mode: requireAll, Parsed: true}},
+		{text: "首选物理", want: SubjectRequirement{First: "物理", Required: []string{}, Mode: requireNone, Parsed: true}},
+		{text: "化学或生物", want: SubjectRequirement{Required: []string{"化学", "生物"}, Mode: requireAny, Parsed: true}},
+		{text: "物理/化学", want: SubjectRequirement{Required: []string{"物理", "化学"}, Mode: requireAny, Parsed: true}},
+		{text: "物理和化学", want: SubjectRequirement{Required: []string{"物理", "化学"}, Mode: requireAll, Parsed: true}},
+		{text: "物化生", want: SubjectRequirement{Required: []string{"物理", "化学", "生物"}, Mode: requireAll, Parsed: true}},
+		{text: "史地", want: SubjectRequirement{Required: []string{"历史", "地理"}, Mode: requireAll, Parsed: true}},
+		{text: "生物", want: SubjectRequirement{Required: []string{"生物"}, Mode: requireAll, Parsed: true}},
+		{text: "思想政治", want: SubjectRequirement{Required: []string{"政治"}, Mode: requireAll, Parsed: true}},
+		{text: "不限", want: SubjectRequirement{Required: []string{}, Mode: requireNone, Parsed: true}},
+		{text: "", first: "物理", want: SubjectRequirement{First: "物理", Required: []string{}, Mode: requireNone, Parsed: true}},
+		{text: "化学、生物(2选1)", want: SubjectRequirement{Required: []string{"化学", "生物"}, Mode: requireAny, Parsed: true}},
+		{text: "化学、生物(二选一)", want: SubjectRequirement{Required: []string{"化学", "生物"}, Mode: requireAny, Parsed: true}},
+		{text: "物理、化学、生物(3选2)", want: SubjectRequirement{Required: []string{"物理", "化学", "生物"}, Mode: requireSome, Count: 2, Parsed: true}},
+		{text: "物化生三选二", want: SubjectRequirement{Required: []string{"物理", "化学", "生物"}, Mode: requireSome, Count: 2, Parsed: true}},
+		{text: "化学、生物(2选2)", want: SubjectRequirement{Required: []string{"化学", "生物"}, Mode: requireAll, Parsed: true}},
+		{text: "首选物理，再选化学、生物、地理(3选2)", want: SubjectRequirement{First: "物理", Required: []string{"化学", "生物", "地理"}, Mode: requireSome, Count: 2, Parsed: true}},
+		// the other subject is not named
+		{text: "化学、生物(3选2)", wantNone: true},
+		{text: "化学(2选1)", wantNone: true},
+		// a subject outside the understood parts
+		{text: "化学(首选物理)", first: "", want: SubjectRequirement{First: "物理"}, wantNone: true},
+		{text: "见招生章程", wantNone: true},
+	} {
+		got := parseRequirement(c.text, c.first)
+		if c.wantNone {
+			if got.Parsed || got.First != c.want.First {
+				t.Errorf("%q: got %+v, want unparsed with first %q", c.text, got, c.want.First)
+			}
+			continue
+		}
+		c.want.Raw = c.text
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q:\n got %+v\nwant %+v", c.text, got, c.want)
+		}
+	}
+}
+
+func TestParseSubjects(t *testing.T) {
+	for s, want := range map[string][]string{
+		"物理,化学,生物":   {"物理", "化学", "生物"},
+		"物化生":        {"物理", "化学", "生物"},
+		"Physics 化学": {"物理", "化学"},
+		"生物化学":       {"生物", "化学"},
+		"学生":         {},
+		"物":          {},
+	} {
+		if got := parseSubjects(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestEligible(t *testing.T) {
+	physChemBio := []string{"物理", "化学", "生物"}
+	histGeoPol := []string{"历史", "地理", "政治"}
+	for _, c := range []struct {
+		text          string
+		subjects      []string
+		eligible      bool
+		wantUncertain bool
+	}{
+		{"首选物理，再选化学", physChemBio, true, false},
+		{"首选物理，再选化学", histGeoPol, false, false},
+		{"首选物理，再选地理", physChemBio, false, false},
+		{"首选物理，化学必选", []string{"物理", "生物", "地理"}, false, false},
+		{"化学或生物", []string{"物理", "生物", "地理"}, true, false},
+		{"化学或地理", []string{"物理", "生物", "政治"}, false, false},
+		{"物化生", physChemBio, true, false},
+		{"物化生", []string{"物理", "化学", "地理"}, false, false},
+		{"物理、化学、生物(3选2)", []string{"物理", "生物", "地理"}, true, false},
+		{"物理、化学、生物(3选2)", []string{"物理", "地理", "政治"}, false, false},
+		{"不限", histGeoPol, true, false},
+		// not understood: eligible, but not certain
+		{"见招生章程", histGeoPol, true, true},
+		{"化学(2选1)", histGeoPol, true, true},
+	} {
+		eligible, certain := parseRequirement(c.text, "").Eligible(c.subjects)
+		if eligible != c.eligible || certain == c.wantUncertain {
+			t.Errorf("%q for %v: eligible %v certain %v, want eligible %v certain %v",
+				c.text, c.subjects, eligible, certain, c.eligible, !c.wantUncertain)
+		}
+	}
+
+	// a special is only open when its group requirement is met too
+	s := Special{SpInfo: "化学", SgInfo: "首选物理，再选生物"}
+	if eligible, _ := s.EligibleFor([]string{"物理", "化学", "地理"}); eligible {
+		t.Error("group requirement ignored")
+	}
+	if eligible, certain := s.EligibleFor(physChemBio); !eligible || !certain {
+		t.Error("special and group requirement both met, but not eligible")
+	}
+}
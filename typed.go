@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// missingValues are what upstream sends for absent numbers.
+var missingValues = map[string]bool{"": true, "-": true, "--": true, "—": true, "null": true}
+
+// NullInt is an integer which may be missing. it marshals to a json number or null,
+// and unmarshals from a number, null or an upstream string.
+type NullInt struct {
+	Int   int64
+	Valid bool // false means missing
+}
+
+func (n NullInt) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatInt(n.Int, 10)), nil
+}
+
+func (n *NullInt) UnmarshalJSON(data []byte) error {
+	v, err := parseNullInt(string(bytes.Trim(data, `"`)))
+	if err != nil {
+		return err
+	}
+	*n = v
+	return nil
+}
+
+// Value stores a missing NullInt as sql NULL.
+func (n NullInt) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Int, nil
+}
+
+func (n NullInt) String() string {
+	if !n.Valid {
+		return "-"
+	}
+	return strconv.FormatInt(n.Int, 10)
+}
+
+// NullDecimal is a decimal which may be missing, json behaves like NullInt.
+type NullDecimal struct {
+	Float float64
+	Valid bool // false means missing
+}
+
+func (n NullDecimal) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(n.Float, 'f', -1, 64)), nil
+}
+
+func (n *NullDecimal) UnmarshalJSON(data []byte) error {
+	v, err := parseNullDecimal(string(bytes.Trim(data, `"`)))
+	if err != nil {
+		return err
+	}
+	*n = v
+	return nil
+}
+
+func (n NullDecimal) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Float, nil
+}
+
+func (n NullDecimal) String() string {
+	if !n.Valid {
+		return "-"
+	}
+	return strconv.FormatFloat(n.Float, 'f', -1, 64)
+}
+
+// parseNullInt parses an upstream integer string, missing sentinels give an invalid NullInt.
+func parseNullInt(s string) (NullInt, error) {
+	s = strings.TrimSpace(s)
+	if missingValues[s] {
+		return NullInt{}, nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		// integral decimals like "512.0"
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || f != float64(int64(f)) {
+			return NullInt{}, fmt.Errorf("invalid integer %q", s)
+		}
+		i = int64(f)
+	}
+	return NullInt{Int: i, Valid: true}, nil
+}
+
+func parseNullDecimal(s string) (NullDecimal, error) {
+	s = strings.TrimSpace(s)
+	if missingValues[s] {
+		return NullDecimal{}, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return NullDecimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return NullDecimal{Float: f, Valid: true}, nil
+}
+
+// TypedSpecial is a Special with parsed scores and rank. the typed fields shadow the
+// raw string fields of the same json name, so exports carry numbers or null.
+type TypedSpecial struct {
+	Special
+	Max        NullDecimal `json:"max"`
+	Min        NullDecimal `json:"min"`
+	Average    NullDecimal `json:"average"`
+	MinSection NullInt     `json:"min_section"` // lowest admitted rank
+	Warnings   []string    `json:"warnings,omitempty"`
+	// subject selection requirements, only set in new gaokao provinces
+	Requirement      *SubjectRequirement `json:"requirement,omitempty"`
+	GroupRequirement *SubjectRequirement `json:"group_requirement,omitempty"`
+}
+
+// Typed parses score and rank fields. a rank of 0 is treated as missing, values which
+// can not be parsed are kept as missing and reported in Warnings.
+func (s Special) Typed() TypedSpecial {
+	ts := TypedSpecial{Special: s}
+	for _, f := range []struct {
+		name string
+		raw  string
+		dst  *NullDecimal
+	}{
+		{"max", s.Max, &ts.Max},
+		{"min", s.Min, &ts.Min},
+		{"average", s.Average, &ts.Average},
+	} {
+		v, err := parseNullDecimal(f.raw)
+		if err != nil {
+			ts.Warnings = append(ts.Warnings, fmt.Sprintf("%v: %v", f.name, err))
+		}
+		*f.dst = v
+	}
+	rank, err := parseNullInt(s.MinSection)
+	if err != nil {
+		ts.Warnings = append(ts.Warnings, fmt.Sprintf("min_section: %v", err))
+	}
+	if rank.Valid && rank.Int == 0 {
+		rank = NullInt{}
+	}
+	ts.MinSection = rank
+	if req := s.SpecialRequirement(); req.Raw != "" || req.First != "" {
+		ts.Requirement = &req
+	}
+	if req := s.GroupRequirement(); req.Raw != "" {
+		ts.GroupRequirement = &req
+	}
+	return ts
+}
+
+// UnmarshalJSON fills both the raw and the typed fields, it reads upstream items,
+// legacy outputs with string scores and typed outputs.
+func (ts *TypedSpecial) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Special
+		Max        json.RawMessage `json:"max"`
+		Min        json.RawMessage `json:"min"`
+		Average    json.RawMessage `json:"average"`
+		MinSection json.RawMessage `json:"min_section"`
+		Warnings   []string        `json:"warnings"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	raw.Special.Max = rawNumber(raw.Max)
+	raw.Special.Min = rawNumber(raw.Min)
+	raw.Special.Average = rawNumber(raw.Average)
+	raw.Special.MinSection = rawNumber(raw.MinSection)
+	*ts = raw.Special.Typed()
+	if len(ts.Warnings) == 0 {
+		// typed outputs have the unparsable raw values in warnings only
+		ts.Warnings = raw.Warnings
+	}
+	return nil
+}
+
+// rawNumber turns a json number, string or null into the upstream string form.
+func rawNumber(data json.RawMessage) string {
+	s := string(bytes.Trim(data, `"`))
+	if s == "null" {
+		return ""
+	}
+	return s
+}
+
+func typeSpecials(specials []Special) []TypedSpecial {
+	res := make([]TypedSpecial, 0, len(specials))
+	for _, s := range specials {
+		res = append(res, s.Typed())
+	}
+	return res
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// json schemas of every published output, the contract for consumers in other languages.
+//
+//go:embed schema/*.schema.json
+var schemaFS embed.FS
+
+const schemaBaseURL = "https://gk-score/schema/"
+
+// validationIssue is one problem found in an output tree.
+type validationIssue struct {
+	File    string
+	Kind    string
+	Message string
+}
+
+// issue kinds
+const (
+	issueInvalid    = "invalid"
+	issueEmpty      = "empty"
+	issueDuplicate  = "duplicate"
+	issueReference  = "reference"
+	issueUnreadable = "unreadable"
+)
+
+type validator struct {
+	dir     string
+	schemas map[string]*jsonschema.Schema
+	issues  []validationIssue
+	files   int
+
+	schoolIDs map[string]bool
+}
+
+func newValidator(dir string) (*validator, error) {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	entries, err := schemaFS.ReadDir("schema")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		content, err := schemaFS.ReadFile(path.Join("schema", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if err := c.AddResource(schemaBaseURL+e.Name(), bytes.NewReader(content)); err != nil {
+			return nil, err
+		}
+	}
+	v := &validator{dir: dir, schemas: make(map[string]*jsonschema.Schema)}
+	for _, name := range []string{"school_list", "school_info", "ptb", "special_detail"} {
+		if v.schemas[name], err = c.Compile(schemaBaseURL + name + ".schema.json"); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (v *validator) add(file, kind, format string, args ...interface{}) {
+	v.issues = append(v.issues, validationIssue{File: file, Kind: kind, Message: fmt.Sprintf(format, args...)})
+}
+
+// check validates a decoded json document against the named schema.
+func (v *validator) check(file, schema string, doc interface{}) bool {
+	if err := v.schemas[schema].Validate(doc); err != nil {
+		v.add(file, issueInvalid, "%v", err)
+		return false
+	}
+	return true
+}
+
+// readJSON reads file into doc for schema validation and into typed, if not nil.
+func (v *validator) readJSON(file string, typed interface{}) (interface{}, bool) {
+	v.files++
+	content, err := os.ReadFile(path.Join(v.dir, file))
+	if err != nil {
+		v.add(file, issueUnreadable, "%v", err)
+		return nil, false
+	}
+	return v.decode(file, content, typed)
+}
+
+// decode decodes content into doc for schema validation and into typed, if not nil.
+func (v *validator) decode(file string, content []byte, typed interface{}) (interface{}, bool) {
+	var doc interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		v.add(file, issueInvalid, "%v", err)
+		return nil, false
+	}
+	if typed != nil {
+		if err := json.Unmarshal(content, typed); err != nil {
+			v.add(file, issueInvalid, "%v", err)
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// records hands the records of a sub directory, of either sink, to fn. a missing
+// directory has none.
+func (v *validator) records(dir string, fn func(file, key string, content []byte)) {
+	err := readDataset(path.Join(v.dir, dir), nil, func(rec datasetRecord) error {
+		v.files++
+		file, err := filepath.Rel(v.dir, rec.Source)
+		if err != nil {
+			file = rec.Source
+		}
+		fn(file, rec.Key, rec.Data)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		v.add(dir, issueUnreadable, "%v", err)
+	}
+}
+
+func (v *validator) validateSchoolList() {
+	var list school
+	doc, ok := v.readJSON(schoolListFile, &list)
+	if !ok || !v.check(schoolListFile, "school_list", doc) {
+		return
+	}
+	v.schoolIDs = make(map[string]bool, len(list.Data))
+	for _, sc := range list.Data {
+		if v.schoolIDs[sc.SchoolID] {
+			v.add(schoolListFile, issueDuplicate, "school %v listed more than once", sc.SchoolID)
+		}
+		v.schoolIDs[sc.SchoolID] = true
+	}
+}
+
+// knownSchool reports a reference to a school missing from the school list.
+func (v *validator) knownSchool(file, id string) {
+	if v.schoolIDs != nil && !v.schoolIDs[id] {
+		v.add(file, issueReference, "school %v is not in %v", id, schoolListFile)
+	}
+}
+
+func (v *validator) validateSchoolInfo() {
+	v.records(schoolInfoDir, func(file, key string, content []byte) {
+		var profile SchoolProfile
+		doc, ok := v.decode(file, content, &profile)
+		if !ok || !v.check(file, "school_info", doc) {
+			return
+		}
+		v.knownSchool(file, profile.SchoolID)
+	})
+}
+
+func (v *validator) validatePTB() {
+	f, err := os.Open(path.Join(v.dir, schoolPTBFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			v.add(schoolPTBFile, issueUnreadable, "%v", err)
+		}
+		return
+	}
+	defer f.Close()
+	v.files++
+	names := []string{"year", "school_id", "province_id", "type", "batch"}
+	seen := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		file := fmt.Sprintf("%v:%v", schoolPTBFile, line)
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) != len(names) {
+			v.add(file, issueInvalid, "%v fields, want %v", len(fields), len(names))
+			continue
+		}
+		record := make(map[string]interface{}, len(fields))
+		for i, field := range fields {
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				record[names[i]] = field
+				continue
+			}
+			record[names[i]] = json.Number(strconv.Itoa(n))
+		}
+		if !v.check(file, "ptb", record) {
+			continue
+		}
+		key := scanner.Text()
+		if first, ok := seen[key]; ok {
+			v.add(file, issueDuplicate, "same plan as line %v", first)
+			continue
+		}
+		seen[key] = line
+		v.knownSchool(file, fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		v.add(schoolPTBFile, issueUnreadable, "%v", err)
+	}
+}
+
+func (v *validator) validateSpecialDetail() {
+	v.records(specialDetailDir, func(file, key string, content []byte) {
+		var sp SchoolProv
+		doc, ok := v.decode(file, content, &sp)
+		if !ok || !v.check(file, "special_detail", doc) {
+			return
+		}
+		if want := fmt.Sprintf("%v_%v", sp.SchoolID, sp.ProvinceID); key != want {
+			v.add(file, issueReference, "content belongs to %v", want)
+		}
+		v.knownSchool(file, sp.SchoolID)
+		if len(sp.YTBSpecials) == 0 {
+			v.add(file, issueEmpty, "no YTBSpecials")
+		}
+		seenYTB := make(map[string]bool)
+		for _, ytb := range sp.YTBSpecials {
+			ytbKey := fmt.Sprintf("%v_%v_%v", ytb.Year, ytb.Typ, ytb.Batch)
+			if seenYTB[ytbKey] {
+				v.add(file, issueDuplicate, "year/type/batch %v appears more than once", ytbKey)
+			}
+			seenYTB[ytbKey] = true
+			if len(ytb.Special) == 0 {
+				v.add(file, issueEmpty, "no specials in year/type/batch %v", ytbKey)
+			}
+			seen := make(map[string]bool, len(ytb.Special))
+			for _, s := range ytb.Special {
+				if seen[s.key()] {
+					v.add(file, issueDuplicate, "special %v (%v) repeated in year/type/batch %v", s.SpecialID, s.Spname, ytbKey)
+				}
+				seen[s.key()] = true
+			}
+		}
+	})
+}
+
+// validateCommand checks a whole output tree against the published schemas and for
+// empty, duplicate and dangling records. it fails when any issue is found.
+func validateCommand(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	dir := fs.String("dir", ".", "crawl output directory")
+	asJSON := fs.Bool("json", false, "print issues as json")
+	must(fs.Parse(args))
+
+	v, err := newValidator(*dir)
+	if err != nil {
+		return err
+	}
+	v.validateSchoolList()
+	v.validateSchoolInfo()
+	v.validatePTB()
+	v.validateSpecialDetail()
+
+	sort.SliceStable(v.issues, func(i, j int) bool { return v.issues[i].File < v.issues[j].File })
+	if *asJSON {
+		content, err := json.MarshalIndent(v.issues, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+	} else {
+		counts := make(map[string]int)
+		for _, issue := range v.issues {
+			counts[issue.Kind]++
+			fmt.Printf("%v\t%v\t%v\n", issue.File, issue.Kind, issue.Message)
+		}
+		fmt.Printf("%v files checked, %v issues", v.files, len(v.issues))
+		for _, kind := range []string{issueInvalid, issueEmpty, issueDuplicate, issueReference, issueUnreadable} {
+			if counts[kind] != 0 {
+				fmt.Printf(", %v %v", counts[kind], kind)
+			}
+		}
+		fmt.Println()
+	}
+	if len(v.issues) != 0 {
+		return fmt.Errorf("%v issues found", len(v.issues))
+	}
+	return nil
+}